@@ -0,0 +1,83 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/utils"
+	"github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/spf13/cobra"
+)
+
+const cmdName = "export"
+
+type myFlags struct {
+	out   string
+	title string
+	quiet bool
+}
+
+const shortHelp = "Write the web app as a static site that needs no server"
+
+func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command {
+	flags := myFlags{}
+	c := &cobra.Command{
+		Use:   cmdName + " [{path}]",
+		Short: shortHelp,
+		Long: shortHelp + `
+
+This command renders the markdown below the given path just as
+'` + utils.PgmName + ` serve' does, and writes the result, with the nav,
+timeline and code blocks, to a single ` + server.ExportFileName + ` in the --out
+directory.  Open it in a browser, or publish it to any static host.
+
+With no server to run them, code blocks are copied to the clipboard
+when clicked or when Enter is pressed.
+
+Images referenced by relative paths are copied into the --out
+directory, keeping their paths relative to the given path.
+
+  ` + utils.PgmName + ` ` + cmdName + ` --out site {path}
+`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				// As with serving, images are found relative to one folder.
+				return fmt.Errorf("specify a single path")
+			}
+			if len(args) == 0 {
+				args = []string{string(loader.CurrentDir)}
+			}
+			title := flags.title
+			if title == "" {
+				title = strings.Join(args, ",")
+			}
+			dl := server.NewDataLoader(ldr, args, p, title, nil)
+			written, err := dl.Export(flags.out)
+			if !flags.quiet {
+				for _, f := range written {
+					fmt.Println(f)
+				}
+			}
+			return err
+		},
+		SilenceUsage: true,
+	}
+	c.Flags().StringVar(
+		&flags.out,
+		"out",
+		"site",
+		"The directory in which to write the site.")
+	c.Flags().StringVar(
+		&flags.title,
+		"title",
+		"",
+		"Text to use as a title for the webpage.")
+	c.Flags().BoolVar(
+		&flags.quiet,
+		"quiet",
+		false,
+		"Suppress printing the paths of files written.")
+	return c
+}
@@ -15,10 +15,15 @@ const (
 )
 
 func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command {
+	var label string
 	c := &cobra.Command{
 		Use:   cmdName + " [{path}]",
 		Short: shortHelp,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			filter, err := parsren.ParseLabelExpr(label)
+			if err != nil {
+				return err
+			}
 			fld, err := ldr.LoadTrees(args)
 			if err != nil {
 				return err
@@ -28,10 +33,15 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 				return nil
 			}
 			fld.Accept(p)
-			loader.PrintTitles(os.Stdout, p.Filter(parsren.AllBlocks))
+			loader.PrintTitles(os.Stdout, p.Filter(filter))
 			return nil
 		},
 		SilenceUsage: true,
 	}
+	c.Flags().StringVar(
+		&label,
+		"label",
+		"",
+		"List only the code blocks selected by "+parsren.LabelExprUsage+".")
 	return c
 }
@@ -16,9 +16,12 @@ const (
 )
 
 type myFlags struct {
-	label string
-	upTo  int
-	debug bool
+	label   string
+	langs   []string
+	upTo    int
+	debug   bool
+	set     []string
+	setFile string
 }
 
 const shortHelp = "Print code blocks below the given path as a shell script"
@@ -32,6 +35,23 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 
 Any block labelled with @` + string(loader.SkipLabel) + ` will be ignored.
 
+Blocks labelled @` + string(loader.SetupLabel) + ` are printed first, and blocks labelled
+@` + string(loader.TeardownLabel) + ` or @` + string(loader.CleanupLabel) + ` last, for each file holding a
+block that passes the --label filter.
+
+A block with @` + string(loader.NeedsParam) + `={name} or @` + string(loader.NeedsParam) + `={file}#{name} pulls in the named
+block, and whatever it needs, when selected with --label.  Needed
+blocks come first, along with the rest of their file's blocks.
+
+Placeholders like ${NAME} or <your-name> in a block's code are replaced
+by the values of variables declared in the file's front matter, or
+given with --set or --set-file.
+
+Block parameters like @` + string(loader.DirParam) + `={dir}, @` + string(loader.EnvParam) + `={KEY=VALUE} and
+@` + string(loader.RetryParam) + `={count} are honored by wrapping the block's code in
+shell commands.  A block with @` + string(loader.FileParam) + `={path} becomes commands
+writing its code to that file.
+
 To have the effect of a test, pipe the output of this
 command into a shell, e.g.
 
@@ -46,6 +66,14 @@ cleaner output, showing only the failing block and its output streams.
 			if len(args) < 1 {
 				return fmt.Errorf("specify a path")
 			}
+			selector, err := parsren.ParseLabelExpr(flags.label)
+			if err != nil {
+				return err
+			}
+			vars, err := loader.LoadVars(flags.setFile, flags.set)
+			if err != nil {
+				return err
+			}
 			fld, err := ldr.LoadTrees(args)
 			if err != nil {
 				return err
@@ -58,20 +86,36 @@ cleaner output, showing only the failing block and its output streams.
 				loader.NewVisitorDump(os.Stdout).VisitFolder(fld)
 			}
 			fld.Accept(p)
-			filter := parsren.AllBlocksButSkip
-			if flags.label != "" {
-				filter = func(b *loader.CodeBlock) bool {
-					return b.HasLabel(loader.Label(flags.label)) &&
-						!b.HasLabel(loader.SkipLabel)
-				}
+			if selector, err = parsren.SelectWithNeeds(p, selector); err != nil {
+				return err
+			}
+			blocks, err := parsren.FilterWithLifecycle(p, selector, parsren.And(
+				parsren.AllBlocksButSkip,
+				parsren.NotExpectedOutput,
+				parsren.InLangs(flags.langs)))
+			if err != nil {
+				return err
 			}
-			blocks := p.Filter(filter)
 			if flags.upTo > len(blocks) {
 				return fmt.Errorf("only %d blocks passed the filter", len(blocks))
 			}
 			if flags.upTo > 0 {
 				blocks = blocks[:flags.upTo]
 			}
+			if blocks, err = loader.ExpandChunks(
+				blocks, p.Filter(parsren.AllBlocks)); err != nil {
+				return err
+			}
+			if blocks, err = loader.ExpandVars(blocks, vars); err != nil {
+				// The reader of the script can fix it.
+				slog.Warn(err.Error())
+			}
+			for _, b := range blocks {
+				if err = b.ValidateParams(); err != nil {
+					return fmt.Errorf(
+						"block %q at %s; %w", b.UniqName(), b.Location(), err)
+				}
+			}
 			loader.PrintBlocks(os.Stdout, blocks)
 			return nil
 		},
@@ -85,7 +129,24 @@ cleaner output, showing only the failing block and its output streams.
 		&flags.label,
 		"label",
 		"",
-		"Print only the code blocks that have this label")
+		"Print only the code blocks selected by "+parsren.LabelExprUsage+".")
+	c.Flags().StringSliceVar(
+		&flags.langs,
+		"lang",
+		nil,
+		"Print only the code blocks fenced with one of these languages, e.g. 'shell,bash'.")
+	c.Flags().StringArrayVar(
+		&flags.set,
+		"set",
+		nil,
+		"Replace placeholders of a variable, given as NAME=VALUE, in the code. "+
+			"May be repeated.")
+	c.Flags().StringVar(
+		&flags.setFile,
+		"set-file",
+		"",
+		"Replace placeholders of the variables in this file, "+
+			"one NAME=VALUE per line, in the code.")
 	if utils.AllowDebug {
 		c.Flags().BoolVar(
 			&flags.debug,
@@ -7,21 +7,39 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/runner"
+	"github.com/monopole/mdrip/v2/internal/shellrunner"
+	"github.com/monopole/mdrip/v2/internal/terminal"
 	"github.com/monopole/mdrip/v2/internal/tmux"
 	"github.com/monopole/mdrip/v2/internal/utils"
 	"github.com/monopole/mdrip/v2/internal/web/server"
 	"github.com/spf13/cobra"
 )
 
-const cmdName = "serve"
+const (
+	cmdName       = "serve"
+	flagRunner    = "runner"
+	terminalShell = "/bin/bash"
+	// shellIdleTime is how long an --in-browser shell may go unused.
+	shellIdleTime = time.Hour
+)
 
 type myFlags struct {
 	port        int
 	title       string
+	langs       []string
+	label       string
 	useHostName bool
+	set         []string
+	setFile     string
+	inBrowser   bool
+	terminal    bool
+	runner      string
+	timeOut     time.Duration
 }
 
 // hostAndPort for the server.
@@ -51,7 +69,7 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 	flags := myFlags{}
 	c := &cobra.Command{
 		Use:     cmdName,
-		Short:   "Serve a web app that runs code blocks in tmux",
+		Short:   "Serve a web app that runs code blocks in a terminal",
 		Example: utils.PgmName + " " + cmdName + " {path/to/folder}",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 1 {
@@ -63,13 +81,45 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 			if len(args) == 0 {
 				args = []string{string(loader.CurrentDir)}
 			}
+			selector, err := parsren.ParseLabelExpr(flags.label)
+			if err != nil {
+				return err
+			}
+			if flags.inBrowser && flags.terminal ||
+				(flags.inBrowser || flags.terminal) &&
+					cmd.Flags().Changed(flagRunner) {
+				return fmt.Errorf(
+					"specify at most one of --in-browser, --terminal and --" + flagRunner)
+			}
+			if flags.terminal && !terminal.Supported {
+				return fmt.Errorf("--terminal is only supported on Linux")
+			}
+			vars, err := loader.LoadVars(flags.setFile, flags.set)
+			if err != nil {
+				return err
+			}
 			dl := server.NewDataLoader(
-				ldr, args, p, makeTitle(flags.title, args))
+				ldr, args, p, makeTitle(flags.title, args),
+				parsren.And(
+					selector,
+					parsren.NotExpectedOutput,
+					parsren.InLangs(flags.langs)))
 			// Heat up the cache, and see if the args are okay.
 			if err := dl.LoadAndRender(); err != nil {
 				return fmt.Errorf("data loader fail; %w", err)
 			}
-			s, err := server.NewServer(dl, getCommandRunner())
+			var codeWriter io.Writer
+			switch {
+			case flags.inBrowser:
+				codeWriter = shellrunner.New(flags.timeOut, shellIdleTime)
+			case flags.terminal:
+				codeWriter = terminal.New(terminalShell)
+			default:
+				if codeWriter, err = getCommandRunner(flags.runner); err != nil {
+					return err
+				}
+			}
+			s, err := server.NewServer(dl, codeWriter, vars)
 			if err != nil {
 				return err
 			}
@@ -82,6 +132,54 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 		"title",
 		"",
 		"Text to use as a title for the webpage.")
+	c.Flags().StringSliceVar(
+		&flags.langs,
+		"lang",
+		nil,
+		"Run only the code blocks fenced with one of these languages, e.g. 'shell,bash'.")
+	c.Flags().StringVar(
+		&flags.label,
+		"label",
+		"",
+		"Run only the code blocks selected by "+parsren.LabelExprUsage+".")
+	c.Flags().StringArrayVar(
+		&flags.set,
+		"set",
+		nil,
+		"Replace placeholders of a variable, given as NAME=VALUE, in code "+
+			"sent to the runner. May be repeated. The web app asks for missing values.")
+	c.Flags().StringVar(
+		&flags.setFile,
+		"set-file",
+		"",
+		"Replace placeholders of the variables in this file, "+
+			"one NAME=VALUE per line, in code sent to the runner.")
+	c.Flags().StringVar(
+		&flags.runner,
+		flagRunner,
+		"",
+		"Where to send code blocks, as NAME or NAME:TARGET.  If not given, "+
+			"use the first running multiplexer or terminal found.  "+
+			"Runners:"+runner.Usage())
+	c.Flags().BoolVar(
+		&flags.inBrowser,
+		"in-browser",
+		false,
+		"Rather than send blocks to a runner, run them in bash on this machine, "+
+			"one shell per browser session, and show their output in the page.  "+
+			"A shell unused for an hour is stopped.")
+	c.Flags().DurationVar(
+		&flags.timeOut,
+		"block-time-out",
+		5*time.Minute,
+		"With --in-browser, the max amount of time a block may run.")
+	c.Flags().BoolVar(
+		&flags.terminal,
+		"terminal",
+		false,
+		"Rather than send blocks to a runner, type them into a shell on this "+
+			"machine that the web app shows in a terminal pane (key 't').  "+
+			"Linux only.")
 	c.Flags().IntVar(
 		&flags.port,
 		"port",
@@ -95,22 +193,27 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 	return c
 }
 
-func getCommandRunner() io.Writer {
-	tx, err := tmux.NewTmux(tmux.PgmName)
-	if err != nil || tx == nil {
-		slog.Warn(tmux.PgmName+" not available", "err", err)
-		return &fakeTmux{}
+// getCommandRunner returns the runner named by the spec, else the
+// first runner found to be available.  Lacking one, the app can still
+// show markdown, but refuses to run blocks.
+func getCommandRunner(spec string) (runner.Runner, error) {
+	if spec != "" {
+		return runner.New(spec)
 	}
-	if !tx.IsUp() {
-		slog.Warn(tmux.PgmName + " executable present, but not running")
-		return &fakeTmux{}
+	r, err := runner.Find()
+	if err != nil {
+		slog.Warn("no runner available, so blocks won't run; "+
+			"use --"+flagRunner+" to pick one", "err", err)
+		return runner.Disabled(err), nil
 	}
-	return tx
+	return r, nil
 }
 
-type fakeTmux struct{}
+// Blocks with a session parameter go to a tmux window of that name.
+var _ server.SessionWriter = &tmux.Tmux{}
 
-func (tx *fakeTmux) Write(bytes []byte) (int, error) {
-	slog.Debug("Would run", "codeSnip", utils.Summarize(bytes))
-	return 0, nil
-}
+// Blocks run on the server, with output streamed to the browser.
+var _ server.StreamingRunner = &shellrunner.ShellRunner{}
+
+// Blocks are typed into a shell shown in the browser.
+var _ server.TerminalWriter = &terminal.Terminal{}
@@ -0,0 +1,136 @@
+package tangle
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+const (
+	cmdName = "tangle"
+)
+
+type myFlags struct {
+	label   string
+	out     string
+	quiet   bool
+	set     []string
+	setFile string
+}
+
+const shortHelp = "Write code blocks below the given path to the files they name"
+
+func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command {
+	flags := myFlags{}
+	c := &cobra.Command{
+		Use:   cmdName + " [{path}]",
+		Short: shortHelp,
+		Long: shortHelp + `
+
+A block with @` + string(loader.FileParam) + `={path} holds the content of a file, rather
+than commands to run, e.g.
+
+  <!-- @` + string(loader.FileParam) + `=cmd/hello/main.go -->
+
+This command writes such blocks, optionally selected by label, to
+their files below --out, creating directories as needed.  Blocks naming
+the same file are written in order; each replaces the file's content,
+unless labelled @` + string(loader.AppendLabel) + `.  Use @` + string(loader.ModeParam) + `={octal} to set the file's
+permissions, e.g. @` + string(loader.ModeParam) + `=0755 for a script.
+
+A line holding just a noweb style reference, like <<imports>>, is
+replaced by the code of the block of that name, indented to match.
+Such chunks may hold references of their own.  '` + utils.PgmName + ` test' doesn't
+run chunks, unless they're also blocks with @` + string(loader.FileParam) + `.
+
+Blocks labelled @` + string(loader.SkipLabel) + ` are written too, since skipping
+is about running, not about what a file holds.
+
+Placeholders like ${NAME} or <your-name> are replaced as in '` + utils.PgmName + ` print'.
+
+  ` + utils.PgmName + ` ` + cmdName + ` --out /tmp/src {path}
+`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			selector, err := parsren.ParseLabelExpr(flags.label)
+			if err != nil {
+				return err
+			}
+			vars, err := loader.LoadVars(flags.setFile, flags.set)
+			if err != nil {
+				return err
+			}
+			fld, err := ldr.LoadTrees(args)
+			if err != nil {
+				return err
+			}
+			if fld == nil {
+				slog.Warn("No markdown found.")
+				return nil
+			}
+			fld.Accept(p)
+			blocks := p.Filter(parsren.And(
+				selector, parsren.NotExpectedOutput, isFile))
+			if len(blocks) == 0 {
+				slog.Warn("No blocks with @" + string(loader.FileParam) + " found.")
+				return nil
+			}
+			for _, b := range blocks {
+				if err = b.ValidateParams(); err != nil {
+					return fmt.Errorf(
+						"block %q at %s; %w", b.UniqName(), b.Location(), err)
+				}
+			}
+			if blocks, err = loader.ExpandChunks(
+				blocks, p.Filter(parsren.AllBlocks)); err != nil {
+				return err
+			}
+			if blocks, err = loader.ExpandVars(blocks, vars); err != nil {
+				return err
+			}
+			written, err := loader.Tangle(blocks, flags.out)
+			if !flags.quiet {
+				for _, f := range written {
+					fmt.Println(f)
+				}
+			}
+			return err
+		},
+		SilenceUsage: true,
+	}
+	c.Flags().StringVar(
+		&flags.label,
+		"label",
+		"",
+		"Write only the code blocks selected by "+parsren.LabelExprUsage+".")
+	c.Flags().StringVar(
+		&flags.out,
+		"out",
+		".",
+		"The directory below which to write files.")
+	c.Flags().BoolVar(
+		&flags.quiet,
+		"quiet",
+		false,
+		"Suppress printing the paths of files written.")
+	c.Flags().StringArrayVar(
+		&flags.set,
+		"set",
+		nil,
+		"Replace placeholders of a variable, given as NAME=VALUE, in the code. "+
+			"May be repeated.")
+	c.Flags().StringVar(
+		&flags.setFile,
+		"set-file",
+		"",
+		"Replace placeholders of the variables in this file, "+
+			"one NAME=VALUE per line, in the code.")
+	return c
+}
+
+func isFile(b *loader.CodeBlock) bool {
+	return b.IsFile()
+}
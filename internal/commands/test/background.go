@@ -0,0 +1,129 @@
+package test
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/utils"
+)
+
+// bgProc is a process group started by a block labelled
+// with loader.BackgroundLabel.
+type bgProc struct {
+	name string
+	// pid is the pid of the group leader, and so the group's id.
+	pid int
+}
+
+// makeBackgroundCommand returns a command that runs the block in a
+// subshell in its own process group, with input from /dev/null and
+// output to the log file.  The command prints the pid of the subshell.
+// The subshell clears the ERR trap, since its failures happen
+// while other blocks run.
+func makeBackgroundCommand(b *loader.CodeBlock, logFile string) string {
+	return "unset " + lineBaseVar + `
+set -m
+(
+trap - ERR
+` + b.Script() + `) </dev/null >` + loader.ShellQuote(logFile) + ` 2>&1 &
+set +m
+echo $!
+`
+}
+
+// parseBgPid returns the pid printed by a background command.
+func parseBgPid(out []string) (int, error) {
+	if len(out) > 0 {
+		if pid, err := strconv.Atoi(strings.TrimSpace(out[len(out)-1])); err == nil {
+			return pid, nil
+		}
+	}
+	return 0, fmt.Errorf("unable to find pid of background block in %q", out)
+}
+
+// logFileFor returns a new log file path for the block,
+// making the runner's log directory if need be.
+func (rn *runner) logFileFor(b *loader.CodeBlock) (string, error) {
+	if rn.logDir == "" {
+		dir, err := os.MkdirTemp("", utils.PgmName+"-logs-")
+		if err != nil {
+			return "", fmt.Errorf("unable to make log dir; %w", err)
+		}
+		rn.logDir = dir
+	}
+	rn.numLogs++
+	return filepath.Join(rn.logDir,
+		fmt.Sprintf("%d-%s.log", rn.numLogs, b.UniqName())), nil
+}
+
+// stopNamed stops the background processes of the named blocks.
+func (rn *runner) stopNamed(names []string) error {
+	for _, name := range names {
+		found := false
+		for i := 0; i < len(rn.bg); i++ {
+			if rn.bg[i].name != name {
+				continue
+			}
+			found = true
+			rn.bg[i].kill()
+			rn.bg = append(rn.bg[:i], rn.bg[i+1:]...)
+			i--
+		}
+		if !found {
+			return fmt.Errorf(
+				"@%s=%s; no such background block is running", loader.StopParam, name)
+		}
+	}
+	return nil
+}
+
+// stopBackground stops all background processes.
+func (rn *runner) stopBackground() {
+	for _, p := range rn.bg {
+		p.kill()
+	}
+	rn.bg = nil
+}
+
+// removeLogs removes the logs of background blocks, unless a
+// block failed, in which case the logs are kept to help debugging.
+func (rn *runner) removeLogs(keep bool) {
+	if rn.logDir == "" {
+		return
+	}
+	if keep {
+		_, _ = fmt.Fprintf(os.Stderr,
+			"logs of background blocks kept in %s\n", rn.logDir)
+		return
+	}
+	if err := os.RemoveAll(rn.logDir); err != nil {
+		slog.Warn("unable to remove", "dir", rn.logDir, "err", err)
+	}
+}
+
+// kill sends SIGTERM to the process group, then SIGKILL if the
+// group hasn't exited after durationShutdown.  It uses kill(1),
+// rather than the syscall package, to build on all platforms.
+func (p *bgProc) kill() {
+	group := "-" + strconv.Itoa(p.pid)
+	if exec.Command("kill", "-s", "TERM", "--", group).Run() != nil {
+		// Already gone.
+		return
+	}
+	for deadline := time.Now().Add(durationShutdown); time.Now().Before(deadline); {
+		if exec.Command("kill", "-0", "--", group).Run() != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := exec.Command("kill", "-s", "KILL", "--", group).Run(); err != nil {
+		slog.Warn("unable to kill background block", "name", p.name, "err", err)
+	}
+}
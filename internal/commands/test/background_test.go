@@ -0,0 +1,84 @@
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// isAlive returns true if the process is running, i.e. exists
+// and isn't a zombie.
+func isAlive(pid int) bool {
+	out, err := exec.Command("ps", "-o", "stat=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		// ps fails if there's no such process.
+		return false
+	}
+	stat := strings.TrimSpace(string(out))
+	return stat != "" && !strings.HasPrefix(stat, "Z")
+}
+
+func readPid(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return pid
+}
+
+// TestBackgroundCleanup checks that the processes of background
+// blocks, including their children, are killed at the end of their
+// file, or when a block stops them.
+func TestBackgroundCleanup(t *testing.T) {
+	if _, err := exec.LookPath("ps"); err != nil {
+		t.Skip("ps not found")
+	}
+	pids := t.TempDir()
+	// startSleep is a block starting a background sleep, recording
+	// the pids of the block's subshell and of the sleep.  The block
+	// waits for the pids, lest a later block stop it first.
+	startSleep := func(name string) string {
+		p := filepath.Join(pids, name)
+		return "<!-- @" + name + " @background @waitfor=file:" + p + ".sleep -->\n```bash\n" +
+			"echo $BASHPID > " + p + ".shell\n" +
+			"sleep 300 &\n" +
+			"echo $! > " + p + ".sleep\n" +
+			"wait\n```\n\n"
+	}
+	// alive and dead are commands checking the recorded sleep of
+	// a block, ignoring zombies.
+	alive := func(name string) string {
+		return "ps -o stat= -p $(cat " + filepath.Join(pids, name) +
+			".sleep) | grep -q '^[^Z]'\n"
+	}
+	dead := func(name string) string {
+		return "! " + alive(name)
+	}
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n" + startSleep("first") + startSleep("second") +
+			"<!-- @check @stop=first -->\n```bash\n" +
+			"sleep 0.2\n" + dead("first") + alive("second") + "```\n",
+		"b.md": "# B\n\n" + startSleep("third") +
+			"<!-- @checkAgain -->\n```bash\n" +
+			"sleep 0.2\n" + dead("second") + alive("third") + "```\n",
+	})
+	_, errOut, err := runCommand(t, dir, ".")
+	if !assert.NoError(t, err, errOut) {
+		t.FailNow()
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		for _, kind := range []string{"shell", "sleep"} {
+			pid := readPid(t, filepath.Join(pids, name+"."+kind))
+			assert.False(t, isAlive(pid), "%s %s, pid %d", name, kind, pid)
+		}
+	}
+}
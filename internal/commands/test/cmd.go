@@ -2,12 +2,12 @@ package test
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/parsren"
-	"github.com/monopole/shexec"
-	"github.com/monopole/shexec/channeler"
+	"github.com/monopole/mdrip/v2/internal/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -19,8 +19,23 @@ const (
 )
 
 type myFlags struct {
-	quiet        bool
-	label        string
+	quiet       bool
+	label       string
+	langs       []string
+	match       string
+	reports     []string
+	keepGoing   string
+	parallel    int
+	hermetic    bool
+	keepSandbox bool
+	envVars     []string
+	envFile     string
+	set         []string
+	setFile     string
+	// env holds the variables from envFile and envVars.
+	env          []string
+	sleep        time.Duration
+	waitTimeOut  time.Duration
 	blockTimeOut time.Duration
 }
 
@@ -37,25 +52,137 @@ This command silently runs extracted code blocks, optionally selected by label.
 
 Any block labelled with @` + string(loader.SkipLabel) + ` will be ignored.
 
+In each file holding a block that passes the --label filter, blocks
+labelled @` + string(loader.SetupLabel) + ` run first.  Blocks labelled @` + string(loader.TeardownLabel) + ` or @` + string(loader.CleanupLabel) + `
+run last, even if an earlier block in the file failed.  After a
+failure, the teardown runs in a fresh shell, so it shouldn't rely on
+shell variables set by earlier blocks.
+
+Use --lang to avoid running blocks that hold, say, YAML or JSON
+examples rather than shell commands.
+
 The command fails (non-zero exit code) if an extracted code block fails.
+By default, it stops at the first failure; use --keep-going to see all
+failures, and a summary of results per file.  Use --keep-going=tree,
+with the '=', to also run the blocks after a failure in the same file.
+
+A block labelled @` + string(loader.ExpectLabel) + ` holds the expected stdout of the
+block preceding it, as does an 'output' or 'text' fenced block that
+immediately follows a code block.  The command fails if the actual
+stdout doesn't match, per the --match flag, or per the block's own
+@` + string(loader.ExpectParam) + `={mode} parameter.
+
+Blocks may carry parameters that override or add to the flags, e.g.
+
+  <!-- @install @` + string(loader.TimeoutParam) + `=90s @` + string(loader.RetryParam) + `=3 @` + string(loader.DirParam) + `=/tmp/x @` + string(loader.EnvParam) + `=FOO=bar -->
+
+Use --parallel=N to run each file's blocks in a separate shell, in
+a fresh temporary directory, with up to N files running at once.
+Output is printed in the same order as without --parallel.  Since
+files no longer share a shell, a file mustn't depend on variables,
+functions or files created by blocks in another file.
+
+Use --hermetic to keep the caller's environment from affecting the
+results.  Blocks then run in a fresh temporary sandbox directory, with
+HOME, TMPDIR and the XDG dirs inside it, and only a few whitelisted
+variables from the environment.  Add variables with --env and
+--env-file.  The sandbox is removed when done, unless --keep-sandbox
+is set.
+
+A block with @` + string(loader.NeedsParam) + `={name} or @` + string(loader.NeedsParam) + `={file}#{name} pulls in the named
+block, and whatever it needs, when selected with --label.  Needed
+blocks come first, along with the rest of their file's blocks.
+
+A block labelled @` + string(loader.SleepLabel) + ` is followed by a sleep of --sleep, or of
+the block's own @` + string(loader.SleepParam) + `={duration}.  A block may also wait, after it
+runs, for a condition to hold, e.g.
+
+  <!-- @startServer @` + string(loader.WaitForParam) + `=port:8080 @` + string(loader.WaitForParam) + `=file:/tmp/ready -->
+  <!-- @startServer @` + string(loader.WaitForParam) + `=cmd:"curl -sf localhost:8080" @` + string(loader.WaitTimeoutParam) + `=1m -->
+
+The block fails if a condition doesn't hold within --wait-time-out,
+or the block's own @` + string(loader.WaitTimeoutParam) + `={duration}.
+
+A block labelled @` + string(loader.BackgroundLabel) + ` runs in the background, in its own
+process group, with its output going to a log file.  Its processes
+are killed when all blocks in its file have run, or before a later
+block with @` + string(loader.StopParam) + `={name of background block} runs.  Logs are
+removed afterward, unless a block failed.
+
+A block with @` + string(loader.SessionParam) + `={name} runs in a separate shell for that
+session, started on demand and kept until the end of the file, e.g.
+for tutorials that say "in a second terminal, run...".
+
+Placeholders like ${NAME} or <your-name> in a block's code are replaced
+by the values of variables declared in the file's front matter, or
+given with --set or --set-file.  The test fails before running
+anything if a placeholder has no value.
+
+A block with @` + string(loader.FileParam) + `={path} is written to that file, relative to
+the working directory, rather than run; see '` + utils.PgmName + ` tangle'.  As there,
+the path must be relative, and stay below the working directory.
+Blocks used only as chunks of such files aren't run.
+
+A retried block runs in a subshell, so its variable and directory
+changes don't survive.
 
 Output is constrained to show only the content of the failing code block
-and its output and error streams.
+and its output and error streams.  Use --report to also write JUnit XML,
+TAP or JSON reports, with one test case per code block, e.g.
+
+  ` + utils.PgmName + ` ` + cmdName + ` --report ` + reportJunit + `:out.xml --report ` + reportJson + `:out.json {path}
 `,
 		RunE: func(_ *cobra.Command, args []string) error {
+			if err := validateMatchMode(flags.match); err != nil {
+				return err
+			}
+			if err := validateKeepGoing(flags.keepGoing); err != nil {
+				return err
+			}
+			if flags.parallel < 0 {
+				return fmt.Errorf("--parallel must not be negative")
+			}
+			specs, err := parseReportSpecs(flags.reports)
+			if err != nil {
+				return err
+			}
+			vars, err := loader.LoadVars(flags.setFile, flags.set)
+			if err != nil {
+				return err
+			}
+			if flags.env, err = loadEnv(flags.envFile, flags.envVars); err != nil {
+				return err
+			}
+			selector, err := parsren.ParseLabelExpr(flags.label)
+			if err != nil {
+				return err
+			}
 			fld, err := ldr.LoadTrees(args)
 			if err != nil {
 				return err
 			}
 			fld.Accept(p)
-			filter := parsren.AllBlocks
-			if flags.label != "" {
-				filter = func(b *loader.CodeBlock) bool {
-					return b.HasLabel(loader.Label(flags.label))
-				}
+			if selector, err = parsren.SelectWithNeeds(p, selector); err != nil {
+				return err
+			}
+			all := p.Filter(parsren.AllBlocks)
+			// Chunks are parts of files, not commands.
+			chunks := loader.ChunksOnly(all)
+			blocks, err := parsren.FilterWithLifecycle(p, selector, parsren.And(
+				parsren.NotExpectedOutput,
+				parsren.InLangs(flags.langs),
+				func(b *loader.CodeBlock) bool { return !chunks[b] }))
+			if err != nil {
+				return err
+			}
+			blocks, err = loader.ExpandChunks(blocks, all)
+			if err != nil {
+				return err
+			}
+			if blocks, err = loader.ExpandVars(blocks, vars); err != nil {
+				return err
 			}
-			return runTheBlocks(
-				p.Filter(filter), flags.quiet, flags.blockTimeOut)
+			return runTheBlocks(blocks, &flags, specs)
 		},
 		SilenceUsage: true,
 	}
@@ -63,12 +190,97 @@ and its output and error streams.
 		&flags.label,
 		"label",
 		"",
-		"Extract only code blocks with this label.")
+		"Extract only code blocks selected by "+parsren.LabelExprUsage+".")
+	c.Flags().StringSliceVar(
+		&flags.langs,
+		"lang",
+		nil,
+		"Extract only code blocks fenced with one of these languages, e.g. 'shell,bash'.")
 	c.Flags().BoolVar(
 		&flags.quiet,
 		"quiet",
 		false,
 		"Suppress printing of code block names during test.")
+	c.Flags().StringVar(
+		&flags.match,
+		"match",
+		matchTrimmed,
+		"How to compare stdout to expected output; one of "+
+			strings.Join(matchModes, ", ")+".")
+	c.Flags().StringArrayVar(
+		&flags.reports,
+		"report",
+		nil,
+		"Write a report in the form {format}:{path}, where format is one of "+
+			strings.Join(reportFormats, ", ")+
+			". Omit the path to write to stdout, in which case progress "+
+			"goes to stderr. May be repeated.")
+	c.Flags().StringVar(
+		&flags.keepGoing,
+		"keep-going",
+		keepGoingNone,
+		"What to do after a block fails: '"+keepGoingNone+"' stops, '"+
+			keepGoingFile+"' skips the rest of the failing file and continues "+
+			"with the next file, '"+keepGoingTree+"' continues with the next block. "+
+			"Either way, the shell is restarted after a failure. "+
+			"Alone, --keep-going means '"+keepGoingFile+"'; give another value "+
+			"with '=', e.g. --keep-going="+keepGoingTree+", since a value after "+
+			"a space is taken as a path.")
+	c.Flag("keep-going").NoOptDefVal = keepGoingFile
+	c.Flags().IntVar(
+		&flags.parallel,
+		"parallel",
+		0,
+		"Run the blocks of each file in the file's own shell and temporary "+
+			"working directory, with up to this many files at once. "+
+			"Zero runs all blocks in one shell in the current directory.")
+	c.Flags().BoolVar(
+		&flags.hermetic,
+		"hermetic",
+		false,
+		"Run blocks in a temporary sandbox directory, with HOME, TMPDIR and "+
+			"the XDG dirs pointing into it, and only these variables from the "+
+			"environment: "+strings.Join(hermeticVars, ", ")+".")
+	c.Flags().BoolVar(
+		&flags.keepSandbox,
+		"keep-sandbox",
+		false,
+		"Don't remove sandbox directories when done.")
+	c.Flags().StringArrayVar(
+		&flags.envVars,
+		"env",
+		nil,
+		"Set a variable, in the form KEY=VALUE, in the shell's environment. "+
+			"May be repeated.")
+	c.Flags().StringVar(
+		&flags.envFile,
+		"env-file",
+		"",
+		"Set the variables in this file, one KEY=VALUE per line, "+
+			"in the shell's environment.")
+	c.Flags().StringArrayVar(
+		&flags.set,
+		"set",
+		nil,
+		"Replace placeholders of a variable, given as NAME=VALUE, in the code. "+
+			"May be repeated.")
+	c.Flags().StringVar(
+		&flags.setFile,
+		"set-file",
+		"",
+		"Replace placeholders of the variables in this file, "+
+			"one NAME=VALUE per line, in the code.")
+	c.Flags().DurationVar(
+		&flags.sleep,
+		"sleep",
+		2*time.Second,
+		"How long to sleep after a block labelled @"+string(loader.SleepLabel)+".")
+	c.Flags().DurationVar(
+		&flags.waitTimeOut,
+		"wait-time-out",
+		30*time.Second,
+		"The max amount of time to wait for a block's @"+
+			string(loader.WaitForParam)+" conditions.")
 	c.Flags().DurationVar(
 		&flags.blockTimeOut,
 		"block-time-out",
@@ -78,39 +290,27 @@ and its output and error streams.
 	return c
 }
 
-func runTheBlocks(
-	blocks []*loader.CodeBlock, quiet bool, timeout time.Duration) error {
-	const (
-		unlikelyWordOut = rumple + "Out"
-		unlikelyWordErr = rumple + "Err"
-	)
-	sh := shexec.NewShell(shexec.Parameters{
-		Params: channeler.Params{Path: "/bin/bash", Args: []string{"-e"}},
-		SentinelOut: shexec.Sentinel{
-			C: "echo " + unlikelyWordOut,
-			V: unlikelyWordOut,
-		},
-		SentinelErr: shexec.Sentinel{
-			C: "echo " + unlikelyWordErr + " 1>&2",
-			V: unlikelyWordErr,
-		},
-	})
-	if err := sh.Start(durationStartup); err != nil {
-		return err
-	}
-	r := makeReporter(quiet, blocks)
+// validateBlocks returns an error if any block has a bad parameter.
+func validateBlocks(blocks []*loader.CodeBlock, flags *myFlags) error {
 	for _, b := range blocks {
-		r.header(b)
-		if b.HasLabel(loader.SkipLabel) {
-			r.skip()
-			continue
+		if err := b.ValidateParams(); err != nil {
+			return fmt.Errorf("block %q at %s; %w", b.UniqName(), b.Location(), err)
 		}
-		c := shexec.NewRecallCommander(b.Code())
-		if err := sh.Run(timeout, c); err != nil {
-			r.fail(err, b, c)
-			return fmt.Errorf("code block %q failed", b.UniqName())
+		if out := b.ExpectedOutput(); out != nil {
+			if err := validateMatchMode(matchMode(out, flags)); err != nil {
+				return fmt.Errorf(
+					"output of block %q at %s; %w", b.UniqName(), b.Location(), err)
+			}
 		}
-		r.pass()
 	}
-	return sh.Stop(durationShutdown, "")
+	return nil
+}
+
+// matchMode returns the mode to use for matching the given
+// expected output.
+func matchMode(out *loader.CodeBlock, flags *myFlags) string {
+	if m := out.Params().Get(loader.ExpectParam); m != "" {
+		return m
+	}
+	return flags.match
 }
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren/usegold"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFiles writes the files, mapping names to contents, to a new
+// temporary directory, and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755)) ||
+			!assert.NoError(t, os.WriteFile(p, []byte(content), 0o644)) {
+			t.FailNow()
+		}
+	}
+	return dir
+}
+
+// runCommand runs the test command with the given args, from the
+// given directory, returning what it wrote to stdout and stderr.
+// Tests using it mustn't run in parallel, since it swaps out
+// os.Stdout and os.Stderr.
+func runCommand(
+	t *testing.T, dir string, args ...string) (stdOut, stdErr string, err error) {
+	capture := func(f **os.File) func() string {
+		tmp, err := os.CreateTemp(t.TempDir(), "out")
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		old := *f
+		*f = tmp
+		return func() string {
+			*f = old
+			_ = tmp.Close()
+			data, err := os.ReadFile(tmp.Name())
+			assert.NoError(t, err)
+			return string(data)
+		}
+	}
+	restoreOut := capture(&os.Stdout)
+	restoreErr := capture(&os.Stderr)
+	t.Chdir(dir)
+	c := NewCommand(
+		loader.New(afero.NewOsFs(), loader.IsMarkDownFile, loader.InNotIgnorableFolder),
+		usegold.NewGParser())
+	c.SetArgs(args)
+	c.SetOut(os.Stderr)
+	c.SetErr(os.Stderr)
+	err = c.Execute()
+	return restoreOut(), restoreErr(), err
+}
+
+func TestFileBlocks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n<!-- @write @file=out/hi.sh -->\n```bash\n<<greet>>\n```\n\n" +
+			"<!-- @greet -->\n```bash\necho hi\nexit 1\n```\n\n" +
+			"<!-- @run -->\n```bash\n[ \"$(bash out/hi.sh)\" = hi ]\n" +
+			"[ ! -x out/hi.sh ]\n```\n",
+	})
+	out, errOut, err := runCommand(t, dir, ".")
+	assert.NoError(t, err, errOut)
+	assert.NotContains(t, out, "greet")
+	c, err := os.ReadFile(filepath.Join(dir, "out", "hi.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, "echo hi\nexit 1\n", string(c))
+
+	dir = writeFiles(t, map[string]string{
+		"a.md": "# A\n\n<!-- @write @file=/tmp/hi.sh -->\n```bash\necho hi\n```\n",
+	})
+	_, _, err = runCommand(t, dir, ".")
+	assert.ErrorContains(t, err, `want a relative @file path, not "/tmp/hi.sh"`)
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Ways to compare a block's actual stdout to its expected output.
+const (
+	// matchExact wants identical lines.
+	matchExact = "exact"
+	// matchTrimmed wants identical lines after trimming surrounding
+	// white space from each line.
+	matchTrimmed = "trimmed"
+	// matchRegex treats the expected output as a regular expression
+	// that must match somewhere in the actual output.
+	matchRegex = "regex"
+	// matchContains wants the expected output to appear somewhere
+	// in the actual output.
+	matchContains = "contains"
+)
+
+var matchModes = []string{matchExact, matchTrimmed, matchRegex, matchContains}
+
+func validateMatchMode(mode string) error {
+	for _, m := range matchModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"unknown match mode %q; use one of %s",
+		mode, strings.Join(matchModes, ", "))
+}
+
+// outputMismatchErr reports that the actual stdout of a block
+// didn't match its expected output.
+type outputMismatchErr struct {
+	mode     string
+	expected string
+}
+
+func (e *outputMismatchErr) Error() string {
+	return fmt.Sprintf("stdout doesn't match expected output (%s)", e.mode)
+}
+
+// checkOutput returns an error if the actual output lines don't match
+// the expected output under the given mode.
+// The actual output lines arrive without blank lines, so blank lines
+// in the expected output are ignored in all modes.
+func checkOutput(mode string, expected string, actual []string) error {
+	want := nonBlankLines(expected)
+	ok := false
+	switch mode {
+	case matchExact:
+		ok = equalLines(want, actual, func(s string) string { return s })
+	case matchTrimmed:
+		ok = equalLines(want, actual, strings.TrimSpace)
+	case matchRegex:
+		re, err := regexp.Compile(strings.TrimSpace(expected))
+		if err != nil {
+			return fmt.Errorf("bad regex in expected output; %w", err)
+		}
+		ok = re.MatchString(strings.Join(actual, "\n"))
+	case matchContains:
+		ok = strings.Contains(
+			strings.Join(trimAll(actual), "\n"),
+			strings.Join(trimAll(want), "\n"))
+	default:
+		return validateMatchMode(mode)
+	}
+	if ok {
+		return nil
+	}
+	return &outputMismatchErr{mode: mode, expected: expected}
+}
+
+func nonBlankLines(s string) (result []string) {
+	for _, line := range strings.Split(s, "\n") {
+		if len(line) > 0 {
+			result = append(result, line)
+		}
+	}
+	return
+}
+
+func trimAll(lines []string) []string {
+	result := make([]string, len(lines))
+	for i := range lines {
+		result[i] = strings.TrimSpace(lines[i])
+	}
+	return result
+}
+
+func equalLines(a, b []string, normalize func(string) string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if normalize(a[i]) != normalize(b[i]) {
+			return false
+		}
+	}
+	return true
+}
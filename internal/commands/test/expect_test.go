@@ -0,0 +1,130 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOutput(t *testing.T) {
+	actual := []string{"  hello world", "  goodbye  "}
+	for name, tc := range map[string]struct {
+		mode     string
+		expected string
+		actual   []string
+		wantErr  bool
+		// wantMismatch is true if the error should be a mismatch,
+		// rather than some other problem.
+		wantMismatch bool
+	}{
+		"exactMatch": {
+			mode:     matchExact,
+			expected: "  hello world\n  goodbye  \n",
+			actual:   actual,
+		},
+		"exactNoTrailingNewline": {
+			mode:     matchExact,
+			expected: "  hello world\n  goodbye  ",
+			actual:   actual,
+		},
+		"exactBlankLinesIgnored": {
+			mode:     matchExact,
+			expected: "\n  hello world\n\n  goodbye  \n\n",
+			actual:   actual,
+		},
+		"exactMismatchOnSpace": {
+			mode:         matchExact,
+			expected:     "hello world\ngoodbye\n",
+			actual:       actual,
+			wantErr:      true,
+			wantMismatch: true,
+		},
+		"exactMismatchOnCount": {
+			mode:         matchExact,
+			expected:     "  hello world\n",
+			actual:       actual,
+			wantErr:      true,
+			wantMismatch: true,
+		},
+		"trimmedMatch": {
+			mode:     matchTrimmed,
+			expected: "hello world  \ngoodbye\n",
+			actual:   actual,
+		},
+		"trimmedMismatch": {
+			mode:         matchTrimmed,
+			expected:     "hello  world\ngoodbye\n",
+			actual:       actual,
+			wantErr:      true,
+			wantMismatch: true,
+		},
+		"trimmedNoOutput": {
+			mode:     matchTrimmed,
+			expected: "\n",
+			actual:   nil,
+		},
+		"regexMatch": {
+			mode:     matchRegex,
+			expected: "hel+o w.rld\n",
+			actual:   actual,
+		},
+		"regexAcrossLines": {
+			mode:     matchRegex,
+			expected: `(?s)world.*goodbye`,
+			actual:   actual,
+		},
+		"regexTrailingNewlineTrimmed": {
+			mode:     matchRegex,
+			expected: "goodbye  $\n",
+			actual:   actual,
+		},
+		"regexMismatch": {
+			mode:         matchRegex,
+			expected:     "^hello",
+			actual:       actual,
+			wantErr:      true,
+			wantMismatch: true,
+		},
+		"regexBad": {
+			mode:     matchRegex,
+			expected: "hello (world",
+			actual:   actual,
+			wantErr:  true,
+		},
+		"containsMatch": {
+			mode:     matchContains,
+			expected: "goodbye\n",
+			actual:   actual,
+		},
+		"containsMultiLine": {
+			mode:     matchContains,
+			expected: "world\ngoodbye",
+			actual:   actual,
+		},
+		"containsMismatch": {
+			mode:         matchContains,
+			expected:     "farewell\n",
+			actual:       actual,
+			wantErr:      true,
+			wantMismatch: true,
+		},
+		"unknownMode": {
+			mode:     "fuzzy",
+			expected: "hello",
+			actual:   actual,
+			wantErr:  true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := checkOutput(tc.mode, tc.expected, tc.actual)
+			if !tc.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			var mErr *outputMismatchErr
+			assert.Equal(t, tc.wantMismatch, errors.As(err, &mErr))
+		})
+	}
+}
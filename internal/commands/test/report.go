@@ -0,0 +1,334 @@
+package test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+)
+
+// Machine-readable report formats.
+const (
+	reportJunit = "junit"
+	reportTap   = "tap"
+	reportJson  = "json"
+)
+
+var reportFormats = []string{reportJunit, reportTap, reportJson}
+
+// reportSpec says where to write a report in a particular format.
+type reportSpec struct {
+	format string
+	// path is the file to write, or empty for stdout.
+	path string
+}
+
+// parseReportSpecs parses arguments like "junit:out.xml".
+// If the path is omitted, the report goes to stdout, which
+// only one report may do.
+func parseReportSpecs(args []string) ([]reportSpec, error) {
+	result := make([]reportSpec, len(args))
+	for i, arg := range args {
+		format, path, _ := strings.Cut(arg, ":")
+		if !isReportFormat(format) {
+			return nil, fmt.Errorf(
+				"unknown report format %q in %q; use one of %s",
+				format, arg, strings.Join(reportFormats, ", "))
+		}
+		result[i] = reportSpec{format: format, path: path}
+	}
+	if n := numToStdout(result); n > 1 {
+		return nil, fmt.Errorf(
+			"%d reports would go to stdout; give all but one a path", n)
+	}
+	return result, nil
+}
+
+// numToStdout returns the number of reports going to stdout.
+func numToStdout(specs []reportSpec) (n int) {
+	for _, s := range specs {
+		if s.path == "" {
+			n++
+		}
+	}
+	return
+}
+
+func isReportFormat(f string) bool {
+	for _, x := range reportFormats {
+		if x == f {
+			return true
+		}
+	}
+	return false
+}
+
+// writeReports writes the results per each spec.
+func writeReports(specs []reportSpec, results []*blockResult) error {
+	for _, spec := range specs {
+		if err := spec.write(results); err != nil {
+			return fmt.Errorf("unable to write %s report; %w", spec.format, err)
+		}
+	}
+	return nil
+}
+
+func (spec reportSpec) write(results []*blockResult) (err error) {
+	w := io.Writer(os.Stdout)
+	if spec.path != "" {
+		var f *os.File
+		f, err = os.Create(spec.path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cErr := f.Close(); err == nil {
+				err = cErr
+			}
+		}()
+		w = f
+	}
+	switch spec.format {
+	case reportJunit:
+		return writeJunit(w, results)
+	case reportTap:
+		return writeTap(w, results)
+	default:
+		return writeJson(w, results)
+	}
+}
+
+// message is a one-line description of a failure or skip.
+func (r *blockResult) message() string {
+	switch r.status {
+	case statusSkip:
+		return r.skipReason
+	case statusFail:
+		var mErr *outputMismatchErr
+		switch {
+		case errors.As(r.err, &mErr):
+			return mErr.Error()
+		case r.exitCode == unknownExitCode, r.exitCode == 0:
+			// Zero means the block ran, but something after it failed.
+			return "error: " + r.err.Error()
+		case r.inPrologue:
+			return fmt.Sprintf(
+				"exit code %d from %q, while applying the block's parameters",
+				r.exitCode, r.failCommand)
+		case r.failLine > 0:
+			return fmt.Sprintf("exit code %d from %q at %s:%d",
+				r.exitCode, r.failCommand, r.block.Path(), r.failLine)
+		default:
+			return fmt.Sprintf(
+				"exit code %d from %q", r.exitCode, r.failCommand)
+		}
+	default:
+		return ""
+	}
+}
+
+type jsonResult struct {
+	Path        string   `json:"path"`
+	Name        string   `json:"name"`
+	Section     string   `json:"section,omitempty"`
+	Labels      []string `json:"labels"`
+	Lang        string   `json:"lang,omitempty"`
+	Line        int      `json:"line,omitempty"`
+	EndLine     int      `json:"endLine,omitempty"`
+	Column      int      `json:"column,omitempty"`
+	Status      string   `json:"status"`
+	Message     string   `json:"message,omitempty"`
+	DurationSec float64  `json:"durationSec"`
+	ExitCode    *int     `json:"exitCode,omitempty"`
+	FailLine    int      `json:"failLine,omitempty"`
+	Stdout      []string `json:"stdout,omitempty"`
+	Stderr      []string `json:"stderr,omitempty"`
+}
+
+func writeJson(w io.Writer, results []*blockResult) error {
+	all := make([]jsonResult, len(results))
+	for i, r := range results {
+		all[i] = jsonResult{
+			Path:        string(r.block.Path()),
+			Name:        r.block.UniqName(),
+			Section:     r.block.HeadingPath(),
+			Labels:      r.block.Labels().Strings(),
+			Lang:        r.block.Lang(),
+			Line:        r.block.Line(),
+			EndLine:     r.block.EndLine(),
+			Column:      r.block.Column(),
+			Status:      string(r.status),
+			Message:     r.message(),
+			DurationSec: r.duration.Seconds(),
+			FailLine:    r.failLine,
+			Stdout:      r.stdOut,
+			Stderr:      r.stdErr,
+		}
+		if r.status != statusSkip && r.exitCode != unknownExitCode {
+			all[i].ExitCode = &r.exitCode
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(all)
+}
+
+func writeTap(w io.Writer, results []*blockResult) error {
+	var b strings.Builder
+	b.WriteString("TAP version 13\n")
+	b.WriteString(fmt.Sprintf("1..%d\n", len(results)))
+	for i, r := range results {
+		desc := fmt.Sprintf("%d - %s %s", i+1, r.block.Location(), r.block.UniqName())
+		switch r.status {
+		case statusPass:
+			b.WriteString("ok " + desc + "\n")
+		case statusSkip:
+			b.WriteString("ok " + desc + " # SKIP " + r.skipReason + "\n")
+		default:
+			b.WriteString("not ok " + desc + "\n")
+			b.WriteString("  ---\n")
+			b.WriteString(fmt.Sprintf("  message: %q\n", r.message()))
+			b.WriteString(fmt.Sprintf("  duration_ms: %d\n", r.duration.Milliseconds()))
+			if len(r.block.Labels()) > 0 {
+				b.WriteString(fmt.Sprintf("  labels: [%s]\n",
+					strings.Join(r.block.Labels().Strings(), ", ")))
+			}
+			writeTapLines(&b, "stdout", r.stdOut)
+			writeTapLines(&b, "stderr", r.stdErr)
+			b.WriteString("  ...\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeTapLines(b *strings.Builder, name string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	b.WriteString("  " + name + ": |\n")
+	for _, line := range lines {
+		b.WriteString("    " + line + "\n")
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Tests   int              `xml:"tests,attr"`
+	Fails   int              `xml:"failures,attr"`
+	Skips   int              `xml:"skipped,attr"`
+	Time    string           `xml:"time,attr"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name  string          `xml:"name,attr"`
+	Tests int             `xml:"tests,attr"`
+	Fails int             `xml:"failures,attr"`
+	Skips int             `xml:"skipped,attr"`
+	Time  string          `xml:"time,attr"`
+	Cases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string           `xml:"name,attr"`
+	ClassName  string           `xml:"classname,attr"`
+	File       string           `xml:"file,attr,omitempty"`
+	Line       int              `xml:"line,attr,omitempty"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Failure    *junitMessage    `xml:"failure,omitempty"`
+	Skipped    *junitMessage    `xml:"skipped,omitempty"`
+	SystemOut  string           `xml:"system-out,omitempty"`
+	SystemErr  string           `xml:"system-err,omitempty"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// writeJunit writes one test suite per markdown file,
+// and one test case per code block.
+func writeJunit(w io.Writer, results []*blockResult) error {
+	var all junitTestSuites
+	var total time.Duration
+	suiteTimes := make(map[loader.FilePath]time.Duration)
+	suiteIndex := make(map[loader.FilePath]int)
+	for _, r := range results {
+		path := r.block.Path()
+		i, ok := suiteIndex[path]
+		if !ok {
+			i = len(all.Suites)
+			suiteIndex[path] = i
+			all.Suites = append(all.Suites, junitTestSuite{Name: string(path)})
+		}
+		suite := &all.Suites[i]
+		tc := junitTestCase{
+			Name:      r.block.UniqName(),
+			ClassName: string(path),
+			File:      string(path),
+			Line:      r.block.Line(),
+			Time:      junitSeconds(r.duration),
+			SystemOut: strings.Join(r.stdOut, "\n"),
+			SystemErr: strings.Join(r.stdErr, "\n"),
+		}
+		var props []junitProperty
+		if labels := r.block.Labels(); len(labels) > 0 {
+			props = append(props, junitProperty{
+				Name: "labels", Value: strings.Join(labels.Strings(), " ")})
+		}
+		if hp := r.block.HeadingPath(); hp != "" {
+			props = append(props, junitProperty{Name: "section", Value: hp})
+		}
+		if len(props) > 0 {
+			tc.Properties = &junitProperties{Properties: props}
+		}
+		switch r.status {
+		case statusFail:
+			tc.Failure = &junitMessage{Message: r.message(), Body: r.block.Code()}
+			suite.Fails++
+			all.Fails++
+		case statusSkip:
+			tc.Skipped = &junitMessage{Message: r.message()}
+			suite.Skips++
+			all.Skips++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		all.Tests++
+		suiteTimes[path] += r.duration
+		total += r.duration
+		suite.Time = junitSeconds(suiteTimes[path])
+	}
+	all.Time = junitSeconds(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(all); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,146 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReportSpecs(t *testing.T) {
+	specs, err := parseReportSpecs(
+		[]string{"junit:out.xml", "tap", "json:a:b.json"})
+	assert.NoError(t, err)
+	assert.Equal(t, []reportSpec{
+		{format: reportJunit, path: "out.xml"},
+		{format: reportTap},
+		{format: reportJson, path: "a:b.json"},
+	}, specs)
+
+	_, err = parseReportSpecs([]string{"html:out.html"})
+	assert.ErrorContains(t, err, "unknown report format")
+
+	_, err = parseReportSpecs([]string{"junit", "json"})
+	assert.ErrorContains(t, err, "2 reports would go to stdout")
+}
+
+// TestReportToStdout checks that progress lines don't corrupt a
+// report written to stdout.
+func TestReportToStdout(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n```bash\necho hello\n```\n\n```bash\necho bye\n```\n",
+	})
+	out, errOut, err := runCommand(t, dir, "--report", "json", ".")
+	assert.NoError(t, err)
+	var results []jsonResult
+	if !assert.NoError(t, json.Unmarshal([]byte(out), &results), out) {
+		t.FailNow()
+	}
+	assert.Len(t, results, 2)
+	assert.Equal(t, []string{"hello"}, results[0].Stdout)
+	assert.Contains(t, errOut, "PASS")
+	assert.NotContains(t, out, "PASS")
+}
+
+func TestReportOfNoBlocks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n```bash\necho hello\n```\n",
+	})
+	for name, args := range map[string][]string{
+		"serial":   nil,
+		"parallel": {"--parallel", "2"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			report := filepath.Join(t.TempDir(), "r.json")
+			_, errOut, err := runCommand(t, dir, append(args,
+				"--report", "json:"+report, "--label", "nothing", ".")...)
+			assert.NoError(t, err, errOut)
+			assert.Empty(t, readJsonReport(t, report))
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// makeResults returns a passing, a failing and a skipped result,
+// from two files.
+func makeResults() []*blockResult {
+	install := loader.NewFile("docs/install.md", nil)
+	pass := loader.NewCodeBlock(install, "echo hello\n", 0, "install", "linux")
+	pass.SetHeadings([]string{"Install", "Linux"})
+	pass.SetPosition(12, 1, 14)
+	fail := loader.NewCodeBlock(install, "echo oops >&2\nfalse\n", 1, "verify")
+	fail.SetHeadings([]string{"Install", "Check <it> & go"})
+	fail.SetPosition(20, 1, 23)
+	use := loader.NewFile("docs/use.md", nil)
+	skip := loader.NewCodeBlock(use, "rm -rf /\n", 0, "danger", loader.SkipLabel)
+	skip.SetPosition(3, 1, 5)
+	for _, b := range []*loader.CodeBlock{pass, fail, skip} {
+		b.SetLang("bash")
+		b.ResetTitle(nil)
+	}
+	return []*blockResult{
+		{
+			block: pass, status: statusPass,
+			duration: 1500 * time.Millisecond,
+			stdOut:   []string{"hello"},
+		},
+		{
+			block: fail, status: statusFail,
+			duration:    250 * time.Millisecond,
+			exitCode:    1,
+			failLine:    22,
+			failCommand: "false",
+			err:         errors.New("exit status 1"),
+			stdOut:      []string{"some", "output"},
+			stdErr:      []string{"oops"},
+		},
+		{
+			block: skip, status: statusSkip,
+			skipReason: "labelled @skip",
+		},
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	for name, write := range map[string]func(io.Writer, []*blockResult) error{
+		"report.xml":  writeJunit,
+		"report.tap":  writeTap,
+		"report.json": writeJson,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			if !assert.NoError(t, write(&b, makeResults())) {
+				t.FailNow()
+			}
+			golden := filepath.Join("testdata", name)
+			if *update {
+				assert.NoError(t, os.WriteFile(golden, b.Bytes(), 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			assert.NoError(t, err)
+			assert.Equal(t, string(want), b.String())
+		})
+	}
+}
+
+// readJsonReport returns the results in a JSON report.
+func readJsonReport(t *testing.T, path string) []jsonResult {
+	data, err := os.ReadFile(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var results []jsonResult
+	if !assert.NoError(t, json.Unmarshal(data, &results)) {
+		t.FailNow()
+	}
+	return results
+}
@@ -1,13 +1,15 @@
 package test
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
-	"github.com/monopole/shexec"
 )
 
 const (
@@ -20,10 +22,12 @@ const (
 )
 
 type reporter struct {
-	f     string
-	count int
-	size  int
-	quiet bool
+	f      string
+	count  int
+	size   int
+	quiet  bool
+	out    io.Writer
+	errOut io.Writer
 }
 
 func makeReporter(quiet bool, blocks []*loader.CodeBlock) *reporter {
@@ -32,16 +36,32 @@ func makeReporter(quiet bool, blocks []*loader.CodeBlock) *reporter {
 	return &reporter{
 		f: fmt.Sprintf("%%%dd/%%%dd  %%%ds  %%-%ds  ",
 			countWidth, countWidth, maxPathLen, maxBlockNameLen),
-		quiet: quiet,
-		size:  len(blocks),
+		quiet:  quiet,
+		size:   len(blocks),
+		out:    os.Stdout,
+		errOut: os.Stderr,
+	}
+}
+
+// forFile returns a reporter, formatted like this one, for the blocks
+// of one file, the first of which is preceded by the given number of
+// blocks.  It writes to the given capture.
+func (r *reporter) forFile(numPreceding int, c *capture) *reporter {
+	return &reporter{
+		f:      r.f,
+		count:  numPreceding,
+		size:   r.size,
+		quiet:  r.quiet,
+		out:    c.writer(false),
+		errOut: c.writer(true),
 	}
 }
 
 func fieldSizes(
 	blocks []*loader.CodeBlock) (maxPathLen, maxBlockNameLen int) {
 	for _, b := range blocks {
-		if len(b.Path()) > maxPathLen {
-			maxPathLen = len(b.Path())
+		if len(b.Location()) > maxPathLen {
+			maxPathLen = len(b.Location())
 		}
 		if len(b.UniqName()) > maxBlockNameLen {
 			maxBlockNameLen = len(b.UniqName())
@@ -55,64 +75,167 @@ func (r *reporter) header(b *loader.CodeBlock) {
 		return
 	}
 	r.count++
-	fmt.Printf(r.f, r.count, r.size, b.Path(), b.UniqName())
+	fmt.Fprintf(r.out, r.f, r.count, r.size, b.Location(), b.UniqName())
 }
 
 func (r *reporter) skip() {
 	if r.quiet {
 		return
 	}
-	fmt.Print(colGray)
-	fmt.Print("SKIP")
-	fmt.Print(colReset)
-	fmt.Println()
+	fmt.Fprint(r.out, colGray)
+	fmt.Fprint(r.out, "SKIP")
+	fmt.Fprint(r.out, colReset)
+	fmt.Fprintln(r.out)
 }
 
-func (r *reporter) pass() {
+func (r *reporter) pass(res *blockResult) {
 	if r.quiet {
 		return
 	}
-	fmt.Print(colGreen)
-	fmt.Print("PASS")
-	fmt.Print(colReset)
-	fmt.Println()
+	fmt.Fprint(r.out, colGreen)
+	fmt.Fprint(r.out, "PASS")
+	fmt.Fprint(r.out, colReset)
+	fmt.Fprintf(r.out, "  %s", roundDuration(res.duration))
+	if res.logFile != "" {
+		fmt.Fprintf(r.out, "  (in background, logging to %s)", res.logFile)
+	}
+	fmt.Fprintln(r.out)
 }
 
-func (r *reporter) fail(
-	_ error, b *loader.CodeBlock, c *shexec.RecallCommander) {
-	// TODO: Get a better error from the infrastructure for reporting.
-	//  Right now it's something like "sentinel not found".
-	//  Capture exit code from subprocess and report that instead.
-
+func (r *reporter) fail(res *blockResult) {
 	if !r.quiet {
-		fmt.Print(colRed)
-		fmt.Print("FAIL")
-		fmt.Print(colReset)
-		fmt.Println()
+		fmt.Fprint(r.out, colRed)
+		fmt.Fprint(r.out, "FAIL")
+		fmt.Fprint(r.out, colReset)
+		fmt.Fprintf(r.out, "  %s", roundDuration(res.duration))
+		fmt.Fprintln(r.out)
 	}
-
-	_, _ = fmt.Fprintf(os.Stderr, "%s %s:\n", b.Path(), b.UniqName())
-	_, _ = fmt.Fprint(os.Stderr, colCyan)
+	b := res.block
+	if hp := b.HeadingPath(); hp != "" {
+		_, _ = fmt.Fprintf(r.errOut, "%s %s (in %s):\n", b.Location(), b.UniqName(), hp)
+	} else {
+		_, _ = fmt.Fprintf(r.errOut, "%s %s:\n", b.Location(), b.UniqName())
+	}
+	_, _ = fmt.Fprint(r.errOut, colCyan)
 	for _, line := range strings.Split(b.Code(), "\n") {
 		if len(line) > 0 {
-			_, _ = fmt.Fprintln(os.Stderr, " ", line)
+			_, _ = fmt.Fprintln(r.errOut, " ", line)
+		}
+	}
+	_, _ = fmt.Fprint(r.errOut, colReset)
+	r.dumpCapture("stdout", res.stdOut, colWhite)
+	r.dumpCapture("stderr", res.stdErr, colRed)
+	_, _ = fmt.Fprintln(r.errOut, res.message())
+	var mErr *outputMismatchErr
+	if errors.As(res.err, &mErr) {
+		r.dumpCapture("expected", nonBlankLines(mErr.expected), colWhite)
+	}
+}
+
+// summary prints a table of the number of passed, failed and
+// skipped blocks in each file, in order of first appearance.
+func (r *reporter) summary(results []*blockResult) {
+	type counts struct {
+		pass, fail, skip int
+	}
+	var paths []loader.FilePath
+	byPath := make(map[loader.FilePath]*counts)
+	var total counts
+	maxPathLen := len(summaryTotal)
+	for _, res := range results {
+		p := res.block.Path()
+		c, ok := byPath[p]
+		if !ok {
+			c = &counts{}
+			byPath[p] = c
+			paths = append(paths, p)
+			if len(p) > maxPathLen {
+				maxPathLen = len(p)
+			}
 		}
+		switch res.status {
+		case statusPass:
+			c.pass++
+			total.pass++
+		case statusFail:
+			c.fail++
+			total.fail++
+		default:
+			c.skip++
+			total.skip++
+		}
+	}
+	f := fmt.Sprintf("%%-%ds  %%6s  %%6s  %%6s\n", maxPathLen)
+	fmt.Fprintln(r.out)
+	fmt.Fprintf(r.out, f, "file", "passed", "failed", "skipped")
+	row := func(name string, c *counts) {
+		fmt.Fprintf(r.out, f, name,
+			strconv.Itoa(c.pass), strconv.Itoa(c.fail), strconv.Itoa(c.skip))
 	}
-	_, _ = fmt.Fprint(os.Stderr, colReset)
-	dumpCapture("stdout", c.DataOut(), colWhite)
-	dumpCapture("stderr", c.DataErr(), colRed)
+	for _, p := range paths {
+		row(string(p), byPath[p])
+	}
+	row(summaryTotal, &total)
 }
 
-func dumpCapture(kind string, lines []string, color string) {
-	_, _ = fmt.Fprint(os.Stderr, kind, ":")
+const summaryTotal = "total"
+
+// roundDuration rounds the duration for display.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Millisecond)
+}
+
+func (r *reporter) dumpCapture(kind string, lines []string, color string) {
+	_, _ = fmt.Fprint(r.errOut, kind, ":")
 	if len(lines) == 0 {
-		_, _ = fmt.Fprintln(os.Stderr, " <empty>")
+		_, _ = fmt.Fprintln(r.errOut, " <empty>")
 		return
 	}
-	_, _ = fmt.Fprintln(os.Stderr)
-	_, _ = fmt.Fprint(os.Stderr, color)
+	_, _ = fmt.Fprintln(r.errOut)
+	_, _ = fmt.Fprint(r.errOut, color)
 	for _, line := range lines {
-		_, _ = fmt.Fprintf(os.Stderr, "  %s\n", line)
+		_, _ = fmt.Fprintf(r.errOut, "  %s\n", line)
 	}
-	_, _ = fmt.Fprint(os.Stderr, colReset)
+	_, _ = fmt.Fprint(r.errOut, colReset)
+}
+
+// capture records writes to an output stream and an error
+// stream, so that they can be replayed later in the same order.
+type capture struct {
+	chunks []chunk
+}
+
+type chunk struct {
+	toErr bool
+	data  []byte
+}
+
+func (c *capture) writer(toErr bool) io.Writer {
+	return &captureWriter{c: c, toErr: toErr}
+}
+
+// replay writes the recorded chunks to the given streams.
+func (c *capture) replay(out, errOut io.Writer) {
+	for _, ch := range c.chunks {
+		w := out
+		if ch.toErr {
+			w = errOut
+		}
+		_, _ = w.Write(ch.data)
+	}
+	c.chunks = nil
+}
+
+type captureWriter struct {
+	c     *capture
+	toErr bool
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.c.chunks = append(w.c.chunks,
+		chunk{toErr: w.toErr, data: append([]byte(nil), p...)})
+	return len(p), nil
 }
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/utils"
+)
+
+const (
+	// lineBaseVar holds the shell's $LINENO just before a block's prologue.
+	lineBaseVar = "mdripLineBase"
+
+	unknownExitCode = -1
+)
+
+// makeErrTrap returns a command that sets a trap to append the exit
+// code, line and text of a failing command to the given file.
+// A file is used rather than stderr, since a shell exiting on error
+// might do so before its stderr is fully consumed.
+// The line is relative to lineBaseVar, and the trap is silent if
+// lineBaseVar is unset, so that failures in the retry wrapper around
+// a block's code aren't reported.
+func makeErrTrap(reportFile string) string {
+	return "trap " + loader.ShellQuote(
+		`mdripErrRc=$?; if [ -n "${`+lineBaseVar+`-}" ]; then `+
+			`echo "$mdripErrRc $((LINENO - `+lineBaseVar+`)) $BASH_COMMAND" >> `+
+			loader.ShellQuote(reportFile)+`; fi`) + " ERR"
+}
+
+// blockStatus is the outcome of a block.
+type blockStatus string
+
+const (
+	statusPass blockStatus = "pass"
+	statusFail blockStatus = "fail"
+	statusSkip blockStatus = "skip"
+)
+
+// blockResult records the outcome of running one code block.
+type blockResult struct {
+	block  *loader.CodeBlock
+	status blockStatus
+	// skipReason says why a skipped block was skipped.
+	skipReason string
+	// err is the error from the shell or from output checking.
+	err error
+	// exitCode is the exit code of the failing command,
+	// or unknownExitCode.
+	exitCode int
+	// failLine is the line number, in the markdown file, of the
+	// failing command, or zero if unknown.
+	failLine int
+	// failCommand is the text of the failing command.
+	failCommand string
+	// inPrologue is true if the failure was in the commands that
+	// honor the block's dir and env parameters.
+	inPrologue bool
+	duration   time.Duration
+	stdOut     []string
+	stdErr     []string
+	// logFile holds the output of a background block.
+	logFile string
+}
+
+// makeCommand returns the command to send to the shell to run the block.
+// The command sets lineBaseVar so that a failure can be mapped back to
+// a line in the markdown.
+func makeCommand(b *loader.CodeBlock) string {
+	prologue, epilogue := b.ScriptParts()
+	retries, _ := b.Retries()
+	return "unset " + lineBaseVar + "\n" + loader.WrapRetries(
+		lineBaseVar+"=$LINENO\n"+prologue+b.Body()+epilogue, retries)
+}
+
+// makeWaitCommand returns a command that waits, for at least the timeout,
+// for each condition in turn to hold.  If a condition doesn't hold in
+// time, the command prints it to stdout.  The command never fails, so
+// that the shell survives a timeout.
+func makeWaitCommand(conds []loader.WaitCondition, timeout time.Duration) string {
+	var b strings.Builder
+	b.WriteString("unset " + lineBaseVar + "\n")
+	// SECONDS ticks in whole seconds, so add one to never stop early.
+	b.WriteString(fmt.Sprintf("mdripDeadline=$((SECONDS + %d))\n",
+		int(math.Ceil(timeout.Seconds()))+1))
+	for _, c := range conds {
+		b.WriteString("until " + c.Test() + "; do\n")
+		b.WriteString("  if [ $SECONDS -ge $mdripDeadline ]; then echo " +
+			loader.ShellQuote(c.String()) + "; break; fi\n")
+		b.WriteString("  sleep 0.2\n")
+		b.WriteString("done\n")
+	}
+	return b.String()
+}
+
+// absorbFailReports reads the reports written by the ERR trap,
+// keeping the information from the last report that refers to a
+// line within the block.
+func (r *blockResult) absorbFailReports(reports []string) {
+	prologue, _ := r.block.ScriptParts()
+	numPrologueLines := strings.Count(prologue, "\n")
+	numCodeLines := strings.Count(r.block.Body(), "\n")
+	for _, line := range reports {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		code, err1 := strconv.Atoi(fields[0])
+		rel, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// rel is 1 for the first line following the line setting lineBaseVar.
+		codeLine := rel - numPrologueLines
+		if codeLine > numCodeLines+1 {
+			// A report from something other than the block.
+			continue
+		}
+		r.exitCode = code
+		r.failCommand = fields[2]
+		r.inPrologue = codeLine < 1
+		r.failLine = 0
+		if !r.inPrologue && !r.block.IsFile() &&
+			r.block.Line() > 0 && codeLine <= numCodeLines {
+			r.failLine = r.block.Line() + codeLine - 1
+		}
+	}
+}
+
+// failReports manages the file written by the shell's ERR trap.
+type failReports struct {
+	file string
+}
+
+func newFailReports() (*failReports, error) {
+	f, err := os.CreateTemp("", utils.PgmName+"-fail-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp file; %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return nil, fmt.Errorf("unable to close %s; %w", f.Name(), err)
+	}
+	return &failReports{file: f.Name()}, nil
+}
+
+func (fr *failReports) path() string {
+	return fr.file
+}
+
+// clear empties the file.
+func (fr *failReports) clear() {
+	if err := os.Truncate(fr.path(), 0); err != nil {
+		slog.Warn("unable to truncate", "file", fr.path(), "err", err)
+	}
+}
+
+// read returns the lines in the file.
+func (fr *failReports) read() []string {
+	data, err := os.ReadFile(fr.path())
+	if err != nil {
+		slog.Warn("unable to read", "file", fr.path(), "err", err)
+		return nil
+	}
+	return nonBlankLines(string(data))
+}
+
+func (fr *failReports) remove() {
+	if err := os.Remove(fr.path()); err != nil {
+		slog.Warn("unable to remove", "file", fr.path(), "err", err)
+	}
+}
@@ -0,0 +1,210 @@
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeBlock returns a block, starting at line 10 of its file,
+// with the given code and parameters.
+func makeBlock(code, params string) *loader.CodeBlock {
+	b := loader.NewCodeBlock(nil, code, 0)
+	b.AddParams(loader.ParseParams(params))
+	b.SetPosition(10, 1, 10+strings.Count(code, "\n")+1)
+	return b
+}
+
+func TestMakeErrTrap(t *testing.T) {
+	assert.Equal(t,
+		`trap 'mdripErrRc=$?; if [ -n "${mdripLineBase-}" ]; then `+
+			`echo "$mdripErrRc $((LINENO - mdripLineBase)) $BASH_COMMAND" `+
+			`>> '\''/tmp/a b'\''; fi' ERR`,
+		makeErrTrap("/tmp/a b"))
+}
+
+func TestMakeCommand(t *testing.T) {
+	assert.Equal(t, `unset mdripLineBase
+mdripLineBase=$LINENO
+echo hi
+`, makeCommand(makeBlock("echo hi\n", "")))
+
+	assert.Equal(t, `unset mdripLineBase
+mdripLineBase=$LINENO
+export A='b'
+mdripOldDir="$PWD"
+cd '/tmp'
+echo hi
+cd "$mdripOldDir"
+`, makeCommand(makeBlock("echo hi", "@env=A=b @dir=/tmp")))
+
+	cmd := makeCommand(makeBlock("echo hi\n", "@retry=2"))
+	assert.True(t, strings.HasPrefix(cmd, "unset mdripLineBase\nmdripOpts=$-\n"))
+	assert.Contains(t, cmd, "set -e\nmdripLineBase=$LINENO\necho hi\n)")
+}
+
+func TestAbsorbFailReports(t *testing.T) {
+	const code = "echo a\nfalse\necho c\n"
+	for name, tc := range map[string]struct {
+		block       *loader.CodeBlock
+		reports     []string
+		exitCode    int
+		failLine    int
+		failCommand string
+		inPrologue  bool
+	}{
+		"none": {
+			block:    makeBlock(code, ""),
+			exitCode: unknownExitCode,
+		},
+		"secondLine": {
+			block:       makeBlock(code, ""),
+			reports:     []string{"1 2 false"},
+			exitCode:    1,
+			failLine:    11,
+			failCommand: "false",
+		},
+		"afterPrologue": {
+			block:       makeBlock(code, "@env=A=b @dir=/tmp"),
+			reports:     []string{"1 5 false"},
+			exitCode:    1,
+			failLine:    11,
+			failCommand: "false",
+		},
+		"inPrologue": {
+			block:       makeBlock(code, "@env=A=b @dir=/tmp"),
+			reports:     []string{"1 3 cd '/tmp'"},
+			exitCode:    1,
+			failCommand: "cd '/tmp'",
+			inPrologue:  true,
+		},
+		"inEpilogue": {
+			block:       makeBlock(code, "@dir=/tmp"),
+			reports:     []string{"2 6 cd \"$mdripOldDir\""},
+			exitCode:    2,
+			failCommand: "cd \"$mdripOldDir\"",
+		},
+		"lastOfRetries": {
+			block:       makeBlock(code, "@retry=2"),
+			reports:     []string{"1 2 false", "1 2 false", "3 3 echo c"},
+			exitCode:    3,
+			failLine:    12,
+			failCommand: "echo c",
+		},
+		"ignoresReportsBeyondBlock": {
+			block:       makeBlock(code, ""),
+			reports:     []string{"1 2 false", "7 9 whatever"},
+			exitCode:    1,
+			failLine:    11,
+			failCommand: "false",
+		},
+		"ignoresMalformed": {
+			block:       makeBlock(code, ""),
+			reports:     []string{"1 2 false", "x 1 y", "1 y z", "1 2"},
+			exitCode:    1,
+			failLine:    11,
+			failCommand: "false",
+		},
+		"commandWithSpaces": {
+			block:       makeBlock(code, ""),
+			reports:     []string{"1 3 echo c d"},
+			exitCode:    1,
+			failLine:    12,
+			failCommand: "echo c d",
+		},
+		"noLineWithoutPosition": {
+			block:       loader.NewCodeBlock(nil, code, 0),
+			reports:     []string{"1 2 false"},
+			exitCode:    1,
+			failCommand: "false",
+		},
+		"noLineForFileBlock": {
+			block:       makeBlock(code, "@file=x.sh"),
+			reports:     []string{"1 2 printf"},
+			exitCode:    1,
+			failCommand: "printf",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := &blockResult{block: tc.block, exitCode: unknownExitCode}
+			r.absorbFailReports(tc.reports)
+			assert.Equal(t, tc.exitCode, r.exitCode)
+			assert.Equal(t, tc.failLine, r.failLine)
+			assert.Equal(t, tc.failCommand, r.failCommand)
+			assert.Equal(t, tc.inPrologue, r.inPrologue)
+		})
+	}
+}
+
+// TestFailLineInShell runs blocks in bash with the ERR trap, to
+// check that the reports it writes map back to the right lines.
+func TestFailLineInShell(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	for name, tc := range map[string]struct {
+		block      *loader.CodeBlock
+		exitCode   int
+		failLine   int
+		inPrologue bool
+	}{
+		"firstLine": {
+			block:    makeBlock("(exit 3)\necho b\n", ""),
+			exitCode: 3,
+			failLine: 10,
+		},
+		"thirdLine": {
+			block:    makeBlock("echo a\necho b\n(exit 4)\n", ""),
+			exitCode: 4,
+			failLine: 12,
+		},
+		"withPrologue": {
+			block:    makeBlock("echo a\n(exit 5)\n", "@env=A=b @dir=/"),
+			exitCode: 5,
+			failLine: 11,
+		},
+		"prologue": {
+			block:      makeBlock("echo a\n", "@dir=/hopefully/not/a/dir"),
+			exitCode:   1,
+			inPrologue: true,
+		},
+		"retried": {
+			block:    makeBlock("echo a\n(exit 6)\n", "@retry=2"),
+			exitCode: 6,
+			failLine: 11,
+		},
+		"retriedWithPrologue": {
+			block:    makeBlock("echo a\n(exit 7)\n", "@retry=1 @env=A=b"),
+			exitCode: 7,
+			failLine: 11,
+		},
+		"retriedPrologue": {
+			block:      makeBlock("echo a\n", "@retry=1 @dir=/hopefully/not/a/dir"),
+			exitCode:   1,
+			inPrologue: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// A space and a quote check that the trap quotes the path.
+			reports := filepath.Join(t.TempDir(), "fail report's")
+			script := makeErrTrap(reports) + "\n" +
+				"echo something before the block\n" + makeCommand(tc.block)
+			assert.Error(t, exec.Command(bash, "-e", "-E", "-c", script).Run())
+			data, err := os.ReadFile(reports)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			r := &blockResult{block: tc.block, exitCode: unknownExitCode}
+			r.absorbFailReports(nonBlankLines(string(data)))
+			assert.Equal(t, tc.exitCode, r.exitCode)
+			assert.Equal(t, tc.failLine, r.failLine)
+			assert.Equal(t, tc.inPrologue, r.inPrologue)
+		})
+	}
+}
@@ -0,0 +1,532 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/utils"
+	"github.com/monopole/shexec"
+	"github.com/monopole/shexec/channeler"
+)
+
+// Values of the --keep-going flag.
+const (
+	// keepGoingNone stops at the first failing block.
+	keepGoingNone = "none"
+	// keepGoingFile skips the remaining blocks in the file holding
+	// the failing block, and continues with the next file.
+	keepGoingFile = "file"
+	// keepGoingTree continues with the block after the failing block.
+	keepGoingTree = "tree"
+)
+
+func validateKeepGoing(v string) error {
+	switch v {
+	case keepGoingNone, keepGoingFile, keepGoingTree:
+		return nil
+	}
+	return fmt.Errorf(
+		"unknown --keep-going value %q; use one of %s, %s, %s",
+		v, keepGoingNone, keepGoingFile, keepGoingTree)
+}
+
+// runner runs code blocks in bash shells, one shell per session.
+type runner struct {
+	flags   *myFlags
+	reports *failReports
+	// sandbox, if not nil, holds the shell's working directory.
+	sandbox *sandbox
+	// stopped is set when a failure should stop all runners.
+	stopped *atomic.Bool
+	// shells maps session names to shells.  The default
+	// session's name is empty.
+	shells map[string]shexec.Shell
+	// failed is true if any block failed.
+	failed bool
+	// bg holds the processes started by background blocks in the
+	// current file.
+	bg []*bgProc
+	// logDir holds the logs of background blocks, if any.
+	logDir  string
+	numLogs int
+	// shell, if not empty, is the shell named by the front
+	// matter of the current file, used in place of bash.
+	shell string
+}
+
+// newRunner returns a runner whose shell runs in a sandbox if
+// asked, or if the flags call for a hermetic shell.  Otherwise
+// the shell runs in the current directory.
+func newRunner(
+	flags *myFlags, useSandbox bool, stopped *atomic.Bool) (*runner, error) {
+	reports, err := newFailReports()
+	if err != nil {
+		return nil, err
+	}
+	rn := &runner{
+		flags: flags, reports: reports, stopped: stopped,
+		shells: make(map[string]shexec.Shell),
+	}
+	if useSandbox || flags.hermetic {
+		if rn.sandbox, err = newSandbox(); err != nil {
+			reports.remove()
+			return nil, err
+		}
+	}
+	return rn, nil
+}
+
+// close stops background processes, and removes the runner's files.
+func (rn *runner) close() {
+	rn.stopBackground()
+	rn.removeLogs(rn.failed)
+	rn.reports.remove()
+	if rn.sandbox != nil {
+		rn.sandbox.remove(rn.flags.keepSandbox)
+	}
+}
+
+func runTheBlocks(
+	blocks []*loader.CodeBlock, flags *myFlags, specs []reportSpec) error {
+	if err := validateBlocks(blocks, flags); err != nil {
+		return err
+	}
+	r := makeReporter(flags.quiet, blocks)
+	if numToStdout(specs) > 0 {
+		// Keep the progress lines and summary out of the report.
+		r.out = os.Stderr
+	}
+	var (
+		results []*blockResult
+		stopErr error
+	)
+	if flags.parallel > 0 {
+		results, stopErr = runInParallel(r, blocks, flags)
+	} else {
+		results, stopErr = runInOneShell(r, blocks, flags)
+	}
+	if results == nil {
+		return stopErr
+	}
+	if err := writeReports(specs, results); err != nil {
+		return err
+	}
+	if flags.keepGoing != keepGoingNone {
+		r.summary(results)
+	}
+	var failed []*blockResult
+	for _, res := range results {
+		if res.status == statusFail {
+			failed = append(failed, res)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return stopErr
+	case 1:
+		return fmt.Errorf("code block %q failed", failed[0].block.UniqName())
+	default:
+		return fmt.Errorf("%d code blocks failed", len(failed))
+	}
+}
+
+// runInOneShell runs all the blocks, one after another, in one shell
+// started in the current directory.  Results are nil only if the
+// shell couldn't be started.
+func runInOneShell(
+	r *reporter, blocks []*loader.CodeBlock,
+	flags *myFlags) ([]*blockResult, error) {
+	rn, err := newRunner(flags, false, &atomic.Bool{})
+	if err != nil {
+		return nil, err
+	}
+	defer rn.close()
+	if _, err = rn.shellFor(""); err != nil {
+		return nil, err
+	}
+	results := rn.runAll(r, blocks)
+	return results, rn.stopShells(true)
+}
+
+// runInParallel runs the blocks of each file in the file's own shell,
+// started in its own temporary directory, running up to
+// flags.parallel files at once.  Output from each file is held back
+// until the output of all preceding files is printed, so output
+// appears in the same order as it would from runInOneShell.
+func runInParallel(
+	r *reporter, blocks []*loader.CodeBlock,
+	flags *myFlags) ([]*blockResult, error) {
+	type job struct {
+		blocks  []*loader.CodeBlock
+		r       *reporter
+		out     *capture
+		results []*blockResult
+		err     error
+		done    chan struct{}
+	}
+	var (
+		jobs    []*job
+		stopped atomic.Bool
+	)
+	offset := 0
+	for _, group := range groupByFile(blocks) {
+		j := &job{blocks: group, out: &capture{}, done: make(chan struct{})}
+		j.r = r.forFile(offset, j.out)
+		offset += len(group)
+		jobs = append(jobs, j)
+	}
+	todo := make(chan *job)
+	var wg sync.WaitGroup
+	for i := 0; i < flags.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range todo {
+				j.results, j.err = runFile(j.r, j.blocks, flags, &stopped)
+				close(j.done)
+			}
+		}()
+	}
+	go func() {
+		for _, j := range jobs {
+			todo <- j
+		}
+		close(todo)
+	}()
+	// Not nil, so that reports are written though no blocks ran.
+	results := make([]*blockResult, 0, len(blocks))
+	var errs []error
+	for _, j := range jobs {
+		<-j.done
+		j.out.replay(r.out, r.errOut)
+		results = append(results, j.results...)
+		errs = append(errs, j.err)
+	}
+	wg.Wait()
+	return results, errors.Join(errs...)
+}
+
+// groupByFile splits the blocks into runs of blocks from the same file.
+func groupByFile(blocks []*loader.CodeBlock) (groups [][]*loader.CodeBlock) {
+	for i, b := range blocks {
+		if i == 0 || b.Path() != blocks[i-1].Path() {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], b)
+	}
+	return
+}
+
+// runFile runs the blocks, all from one file, in a new shell whose
+// working directory is in a new sandbox.  The sandbox is removed
+// afterward.  If the sandbox can't be made, all blocks fail.
+func runFile(
+	r *reporter, blocks []*loader.CodeBlock,
+	flags *myFlags, stopped *atomic.Bool) ([]*blockResult, error) {
+	rn, err := newRunner(flags, true, stopped)
+	if err != nil {
+		return failAll(r, blocks, err), nil
+	}
+	defer rn.close()
+	results := rn.runAll(r, blocks)
+	return results, rn.stopShells(true)
+}
+
+// failAll reports all the blocks as failing with the given error.
+func failAll(
+	r *reporter, blocks []*loader.CodeBlock, err error) []*blockResult {
+	results := make([]*blockResult, len(blocks))
+	for i, b := range blocks {
+		r.header(b)
+		results[i] = &blockResult{
+			block: b, status: statusFail, exitCode: unknownExitCode, err: err}
+		r.fail(results[i])
+	}
+	return results
+}
+
+// runAll runs the blocks, returning one result per block.
+func (rn *runner) runAll(
+	r *reporter, blocks []*loader.CodeBlock) []*blockResult {
+	results := make([]*blockResult, len(blocks))
+	var failedFile loader.FilePath
+	// ranFile is true for files with a block that ran, i.e. files
+	// whose teardown blocks must run.
+	ranFile := make(map[loader.FilePath]bool)
+	for i, b := range blocks {
+		if i > 0 && b.Path() != blocks[i-1].Path() {
+			rn.stopBackground()
+			// Named sessions are specific to a file.
+			if err := rn.stopShells(false); err != nil {
+				slog.Debug("stopping sessions at end of file", "err", err)
+			}
+		}
+		switch {
+		case b.IsTeardown() && ranFile[b.Path()]:
+			// Runs despite earlier failures.
+		case rn.stopped.Load():
+			results[i] = skipped(b, "not run, since an earlier block failed")
+			continue
+		case failedFile != "" && b.Path() == failedFile:
+			r.header(b)
+			results[i] = skipped(
+				b, "not run, since an earlier block in the file failed")
+			r.skip()
+			continue
+		}
+		if missing := rn.missingEnv(b); len(missing) > 0 {
+			r.header(b)
+			results[i] = skipped(b, fmt.Sprintf(
+				"not run, since the file needs %s, which isn't set",
+				strings.Join(missing, ", ")))
+			r.skip()
+			continue
+		}
+		r.header(b)
+		if b.HasLabel(loader.SkipLabel) {
+			results[i] = skipped(b, "labelled @"+string(loader.SkipLabel))
+			r.skip()
+			continue
+		}
+		if shell := shellOf(b); shell != rn.shell {
+			// Shells of the previous file won't do.
+			if err := rn.stopShells(true); err != nil {
+				slog.Debug("stopping shells to change shell", "err", err)
+			}
+			rn.shell = shell
+		}
+		sh, err := rn.shellFor(b.Session())
+		if err != nil {
+			results[i] = &blockResult{
+				block: b, status: statusFail, exitCode: unknownExitCode,
+				err: fmt.Errorf("unable to start shell; %w", err)}
+			r.fail(results[i])
+			rn.stopped.Store(true)
+			continue
+		}
+		ranFile[b.Path()] = true
+		results[i] = rn.runBlock(sh, b)
+		if results[i].status != statusFail {
+			r.pass(results[i])
+			continue
+		}
+		r.fail(results[i])
+		rn.failed = true
+		// The shell is likely dead, and even if not, its state is suspect.
+		if err = rn.stopShell(b.Session()); err != nil {
+			slog.Debug("stopping shell after failure", "err", err)
+		}
+		switch {
+		case rn.flags.keepGoing == keepGoingFile:
+			failedFile = b.Path()
+		case rn.flags.keepGoing == keepGoingTree:
+			if b.IsSetup() {
+				// The rest of the file presumably needs the setup.
+				failedFile = b.Path()
+			}
+		default:
+			rn.stopped.Store(true)
+		}
+	}
+	rn.stopBackground()
+	if err := rn.stopShells(false); err != nil {
+		slog.Debug("stopping sessions at end of file", "err", err)
+	}
+	return results
+}
+
+func skipped(b *loader.CodeBlock, reason string) *blockResult {
+	return &blockResult{block: b, status: statusSkip, skipReason: reason}
+}
+
+// shellOf returns the shell named by the front matter of the
+// block's file, or an empty string if the file names none.
+func shellOf(b *loader.CodeBlock) string {
+	if b.File() == nil || b.File().FrontMatter() == nil {
+		return ""
+	}
+	return b.File().FrontMatter().Shell
+}
+
+// shellFor returns the shell of the given session, starting
+// it if need be.
+func (rn *runner) shellFor(session string) (shexec.Shell, error) {
+	if sh, ok := rn.shells[session]; ok {
+		return sh, nil
+	}
+	sh, err := rn.startShell()
+	if err != nil {
+		return nil, err
+	}
+	rn.shells[session] = sh
+	return sh, nil
+}
+
+// startShell starts a fresh shell, with an ERR trap that
+// writes to the runner's fail reports.
+func (rn *runner) startShell() (shexec.Shell, error) {
+	const (
+		unlikelyWordOut = rumple + "Out"
+		unlikelyWordErr = rumple + "Err"
+	)
+	sh, err := utils.StartShell(shexec.Parameters{
+		Params: rn.shellParams(),
+		SentinelOut: shexec.Sentinel{
+			C: "echo " + unlikelyWordOut,
+			V: unlikelyWordOut,
+		},
+		SentinelErr: shexec.Sentinel{
+			C: "echo " + unlikelyWordErr + " 1>&2",
+			V: unlikelyWordErr,
+		},
+	}, durationStartup)
+	if err != nil {
+		return nil, err
+	}
+	if err = sh.Run(durationStartup, &shexec.DiscardCommander{
+		C: makeErrTrap(rn.reports.path())}); err != nil {
+		return nil, fmt.Errorf("unable to set trap; %w", err)
+	}
+	return sh, nil
+}
+
+// shellParams returns the parameters for starting bash in the
+// runner's sandbox, if any, with the environment called for by
+// the flags.
+func (rn *runner) shellParams() channeler.Params {
+	// -E so that the ERR trap is inherited by functions and subshells.
+	bash := []string{"/bin/bash", "-e", "-E"}
+	if rn.shell != "" {
+		bash[0] = rn.shell
+	}
+	var p channeler.Params
+	if rn.sandbox != nil {
+		p.WorkingDir = rn.sandbox.workDir()
+	}
+	if !rn.flags.hermetic && len(rn.flags.env) == 0 {
+		p.Path, p.Args = bash[0], bash[1:]
+		return p
+	}
+	// channeler.Params has no environment, so use env(1) to set it.
+	p.Path = "/usr/bin/env"
+	if rn.flags.hermetic {
+		p.Args = append([]string{"-i"}, rn.sandbox.hermeticEnv()...)
+	}
+	p.Args = append(p.Args, rn.flags.env...)
+	p.Args = append(p.Args, bash...)
+	return p
+}
+
+// stopShell stops the shell of the given session, if any.
+func (rn *runner) stopShell(session string) error {
+	sh, ok := rn.shells[session]
+	if !ok {
+		return nil
+	}
+	delete(rn.shells, session)
+	return sh.Stop(durationShutdown, "")
+}
+
+// stopShells stops the shells of all named sessions, and
+// optionally that of the default session.
+func (rn *runner) stopShells(andDefault bool) error {
+	var errs []error
+	for session := range rn.shells {
+		if session != "" || andDefault {
+			errs = append(errs, rn.stopShell(session))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runBlock runs the block in the shell, and checks its output.
+func (rn *runner) runBlock(sh shexec.Shell, b *loader.CodeBlock) *blockResult {
+	// Errors were checked in validateBlocks.
+	timeout, _ := b.Timeout()
+	if timeout == 0 {
+		timeout = rn.flags.blockTimeOut
+	}
+	res := &blockResult{
+		block: b, status: statusFail, exitCode: unknownExitCode}
+	if res.err = rn.stopNamed(b.Params().Values(loader.StopParam)); res.err != nil {
+		return res
+	}
+	cmd := makeCommand(b)
+	isBg := b.HasLabel(loader.BackgroundLabel)
+	if isBg {
+		if res.logFile, res.err = rn.logFileFor(b); res.err != nil {
+			return res
+		}
+		cmd = makeBackgroundCommand(b, res.logFile)
+	}
+	rn.reports.clear()
+	c := shexec.NewRecallCommander(cmd)
+	start := time.Now()
+	res.err = sh.Run(timeout, c)
+	res.duration = time.Since(start)
+	res.stdOut = c.DataOut()
+	res.stdErr = c.DataErr()
+	res.absorbFailReports(rn.reports.read())
+	if res.err != nil {
+		if res.exitCode == unknownExitCode && res.duration >= timeout {
+			res.err = fmt.Errorf("timed out after %s; %w", timeout, res.err)
+		}
+		return res
+	}
+	res.exitCode = 0
+	if isBg {
+		var pid int
+		if pid, res.err = parseBgPid(res.stdOut); res.err != nil {
+			return res
+		}
+		res.stdOut = nil
+		rn.bg = append(rn.bg, &bgProc{name: b.UniqName(), pid: pid})
+	} else if out := b.ExpectedOutput(); out != nil {
+		res.err = checkOutput(
+			matchMode(out, rn.flags), out.Code(), res.stdOut)
+		if res.err != nil {
+			return res
+		}
+	}
+	res.err = rn.settle(sh, b)
+	res.duration = time.Since(start)
+	if res.err != nil {
+		return res
+	}
+	res.status = statusPass
+	return res
+}
+
+// settle sleeps and waits for conditions, as the block asks,
+// after the block has run.
+func (rn *runner) settle(sh shexec.Shell, b *loader.CodeBlock) error {
+	// Errors were checked in validateBlocks.
+	d, _ := b.Sleep()
+	if d == 0 && b.HasLabel(loader.SleepLabel) {
+		d = rn.flags.sleep
+	}
+	time.Sleep(d)
+	conds, _ := b.WaitFor()
+	if len(conds) == 0 {
+		return nil
+	}
+	timeout, _ := b.WaitTimeout()
+	if timeout == 0 {
+		timeout = rn.flags.waitTimeOut
+	}
+	c := shexec.NewRecallCommander(makeWaitCommand(conds, timeout))
+	if err := sh.Run(timeout+durationStartup, c); err != nil {
+		return fmt.Errorf("unable to wait for %s; %w", conds[0], err)
+	}
+	if out := c.DataOut(); len(out) > 0 {
+		return fmt.Errorf("timed out after %s waiting for %s", timeout, out[0])
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// summaryRow matches a row of the --keep-going summary, capturing
+// the counts.
+func summaryRow(name string) *regexp.Regexp {
+	return regexp.MustCompile(
+		`(?m)^` + regexp.QuoteMeta(name) + `\s+(\d+)\s+(\d+)\s+(\d+)$`)
+}
+
+func TestKeepGoing(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n```bash\necho a1\n```\n\n" +
+			"```bash\necho a2\nfalse\n```\n\n```bash\necho a3\n```\n",
+		"b.md": "# B\n\n```bash\necho b1\n```\n",
+	})
+	for name, tc := range map[string]struct {
+		args []string
+		// rows maps file names to the counts of passed,
+		// failed and skipped blocks; nil if there's no summary.
+		rows      map[string][]string
+		numPassed int
+		// ranB is true if the blocks of b.md ran.
+		ranB bool
+	}{
+		"none": {
+			args:      []string{"."},
+			numPassed: 1,
+		},
+		"file": {
+			args: []string{"--keep-going", "."},
+			rows: map[string][]string{
+				"a.md":       {"1", "1", "1"},
+				"b.md":       {"1", "0", "0"},
+				summaryTotal: {"2", "1", "1"},
+			},
+			numPassed: 2,
+			ranB:      true,
+		},
+		"tree": {
+			args: []string{"--keep-going=tree", "."},
+			rows: map[string][]string{
+				"a.md":       {"2", "1", "0"},
+				"b.md":       {"1", "0", "0"},
+				summaryTotal: {"3", "1", "0"},
+			},
+			numPassed: 3,
+			ranB:      true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, errOut, err := runCommand(t, dir,
+				append([]string{"--report", "json:" + t.TempDir() + "/r.json"},
+					tc.args...)...)
+			assert.EqualError(t, err, `code block "echoA2False" failed`)
+			assert.Contains(t, errOut, "exit code 1 from \"false\" at a.md:9")
+			for file, want := range tc.rows {
+				m := summaryRow(file).FindStringSubmatch(out)
+				if assert.NotNil(t, m, "no row for %s in\n%s", file, out) {
+					assert.Equal(t, want, m[1:], file)
+				}
+			}
+			if tc.rows == nil {
+				assert.NotContains(t, out, "passed")
+			}
+			assert.Equal(t, tc.numPassed, strings.Count(out, "PASS"), out)
+			assert.Equal(t, tc.ranB, strings.Contains(out, "b.md"), out)
+		})
+	}
+}
+
+// TestParallelOutputOrder checks that output from files run in
+// parallel appears in file order, unmixed, though later files
+// finish first.
+func TestParallelOutputOrder(t *testing.T) {
+	files := make(map[string]string)
+	var want []string
+	const numFiles = 4
+	for i := 1; i <= numFiles; i++ {
+		name := fmt.Sprintf("f%d.md", i)
+		// Earlier files take longer.
+		sleep := fmt.Sprintf("sleep 0.%d", numFiles-i+1)
+		body := fmt.Sprintf("# F%d\n\n<!-- @first%d -->\n```bash\n%s\n```\n\n", i, i, sleep)
+		if i%2 == 1 {
+			// Odd files fail, to check the order of stderr too.
+			body += fmt.Sprintf("<!-- @second%d -->\n```bash\necho fail%d\nfalse\n```\n", i, i)
+		} else {
+			body += fmt.Sprintf("<!-- @second%d -->\n```bash\necho pass%d\n```\n", i, i)
+		}
+		files[name] = body
+		want = append(want, fmt.Sprintf("first%d", i), fmt.Sprintf("second%d", i))
+	}
+	dir := writeFiles(t, files)
+	out, errOut, err := runCommand(
+		t, dir, "--parallel", strconv.Itoa(numFiles), "--keep-going=tree", ".")
+	assert.EqualError(t, err, "2 code blocks failed")
+
+	var got []string
+	for _, line := range nonBlankLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) > 3 && strings.Contains(fields[1], ".md:") {
+			got = append(got, fields[2])
+		}
+	}
+	assert.Equal(t, want, got, out)
+
+	first, third := strings.Index(errOut, "fail1"), strings.Index(errOut, "fail3")
+	assert.True(t, first >= 0 && third > first, errOut)
+	// The failure report of each file is contiguous.
+	assert.Regexp(t,
+		`(?s)second1.*echo fail1.*exit code 1.*second3.*echo fail3.*exit code 1`,
+		errOut)
+}
+
+// TestTeardownAfterFailure checks that teardown blocks run after a
+// block fails, in a fresh shell.
+func TestTeardownAfterFailure(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n<!-- @tidy @teardown -->\n```bash\n" +
+			"echo \"tidy FOO=[${FOO:-}]\" >>" + log + "\n```\n\n" +
+			"<!-- @prepare -->\n```bash\nFOO=bar\n```\n\n" +
+			"<!-- @break -->\n```bash\nfalse\n```\n\n" +
+			"<!-- @unreached -->\n```bash\necho unreached >>" + log + "\n```\n\n" +
+			"<!-- @sweep @cleanup -->\n```bash\necho sweep >>" + log + "\n```\n",
+	})
+	out, _, err := runCommand(t, dir, ".")
+	assert.EqualError(t, err, `code block "break" failed`)
+	data, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	assert.Equal(t, "tidy FOO=[]\nsweep\n", string(data))
+	assert.Equal(t, 3, strings.Count(out, "PASS"), out)
+}
+
+// TestSetupRunsWithLabel checks that setup blocks run when --label
+// selects some other block of their file.
+func TestSetupRunsWithLabel(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n<!-- @other -->\n```bash\nexit 1\n```\n\n" +
+			"<!-- @chosen -->\n```bash\n[ \"$FOO\" = bar ]\n```\n\n" +
+			"<!-- @prepare @setup -->\n```bash\nFOO=bar\n```\n",
+		"b.md": "# B\n\n<!-- @prepareB @setup -->\n```bash\nexit 1\n```\n",
+	})
+	out, _, err := runCommand(t, dir, "--label", "chosen", ".")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(out, "PASS"), out)
+	assert.Regexp(t, `(?s)prepare\s.*chosen\s`, out)
+	assert.NotContains(t, out, "b.md")
+}
+
+// TestSettle checks the sleeps and waits that follow a block.
+func TestSettle(t *testing.T) {
+	tmp := t.TempDir()
+	ready := filepath.Join(tmp, "ready")
+	open, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer open.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_ = closed.Close()
+	for name, tc := range map[string]struct {
+		params  string
+		code    string
+		args    []string
+		wantErr string
+		// atLeast is the least time the command should take.
+		atLeast time.Duration
+	}{
+		"sleepLabel": {
+			params:  "@sleep",
+			args:    []string{"--sleep", "500ms"},
+			atLeast: 500 * time.Millisecond,
+		},
+		"sleepLabelDefault": {
+			params:  "@sleep",
+			atLeast: 2 * time.Second,
+		},
+		"sleepParam": {
+			params:  "@sleep=500ms",
+			args:    []string{"--sleep", "1h"},
+			atLeast: 500 * time.Millisecond,
+		},
+		"file": {
+			params:  "@waitfor=file:" + ready,
+			code:    "(sleep 0.5; touch " + ready + ") &",
+			atLeast: 500 * time.Millisecond,
+		},
+		"fileTimesOut": {
+			params:  "@waitfor=file:" + filepath.Join(tmp, "never") + " @waittimeout=1s",
+			wantErr: "timed out after 1s waiting for file:",
+			atLeast: time.Second,
+		},
+		"port": {
+			params: "@waitfor=port:" + open.Addr().String(),
+		},
+		"portTimesOut": {
+			params:  "@waitfor=port:" + closed.Addr().String() + " @waittimeout=1s",
+			wantErr: "timed out after 1s waiting for port:" + closed.Addr().String(),
+			atLeast: time.Second,
+		},
+		"cmd": {
+			params:  `@waitfor=cmd:"[ -s ` + ready + ` ]"`,
+			code:    "(sleep 0.5; echo x > " + ready + ") &",
+			atLeast: 500 * time.Millisecond,
+		},
+		"cmdTimesOut": {
+			params:  "@waitfor=cmd:false @waittimeout=1s",
+			args:    []string{"--wait-time-out", "1h"},
+			wantErr: "timed out after 1s waiting for cmd:false",
+			atLeast: time.Second,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_ = os.Remove(ready)
+			code := tc.code
+			if code == "" {
+				code = "true"
+			}
+			dir := writeFiles(t, map[string]string{
+				"a.md": "# A\n\n<!-- @settler " + tc.params + " -->\n```bash\n" +
+					code + "\n```\n",
+			})
+			start := time.Now()
+			_, errOut, err := runCommand(t, dir, append(tc.args, ".")...)
+			took := time.Since(start)
+			if tc.wantErr == "" {
+				assert.NoError(t, err, errOut)
+			} else {
+				assert.EqualError(t, err, `code block "settler" failed`)
+				assert.Contains(t, errOut, tc.wantErr)
+			}
+			assert.GreaterOrEqual(t, took, tc.atLeast)
+			if tc.wantErr == "" {
+				// A condition that holds doesn't wait out the timeout.
+				assert.Less(t, took, tc.atLeast+time.Second)
+			}
+		})
+	}
+}
+
+// TestSessions checks that a block with @session runs in its own
+// shell, which stops at the end of the file.
+func TestSessions(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	block := func(name, params, code string) string {
+		return "<!-- @" + name + " " + params + " -->\n```bash\n" + code + "\n```\n\n"
+	}
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n" +
+			block("setMain", "", "FOO=main") +
+			block("setSide", "@session=side",
+				"[ -z \"${FOO:-}\" ]\nBAR=side\necho $$ >"+pidFile) +
+			block("checkMain", "", "[ \"$FOO\" = main ]\n[ -z \"${BAR:-}\" ]") +
+			block("checkSide", "@session=side",
+				"[ \"$BAR\" = side ]\n[ -z \"${FOO:-}\" ]"),
+		"b.md": "# B\n\n" +
+			block("checkSideB", "@session=side",
+				"[ -z \"${BAR:-}\" ]\n! kill -0 $(cat "+pidFile+") 2>/dev/null") +
+			block("checkMainB", "", "[ \"$FOO\" = main ]"),
+	})
+	out, errOut, err := runCommand(t, dir, ".")
+	assert.NoError(t, err, errOut)
+	assert.Equal(t, 6, strings.Count(out, "PASS"), out)
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/utils"
+)
+
+// hermeticVars names the variables that a hermetic shell
+// takes from the caller's environment.
+var hermeticVars = []string{
+	"PATH", "LANG", "LC_ALL", "LC_CTYPE", "TERM", "TZ", "USER", "LOGNAME",
+}
+
+// sandbox is a temporary directory holding a shell's working
+// directory and, for a hermetic shell, its home and temp directories.
+type sandbox struct {
+	root string
+}
+
+func (s *sandbox) workDir() string { return filepath.Join(s.root, "work") }
+func (s *sandbox) homeDir() string { return filepath.Join(s.root, "home") }
+func (s *sandbox) tmpDir() string  { return filepath.Join(s.root, "tmp") }
+
+// xdgDirs relates XDG variables to directories below HOME.
+var xdgDirs = []struct {
+	name, dir string
+}{
+	{"XDG_CONFIG_HOME", ".config"},
+	{"XDG_CACHE_HOME", ".cache"},
+	{"XDG_DATA_HOME", filepath.Join(".local", "share")},
+	{"XDG_STATE_HOME", filepath.Join(".local", "state")},
+}
+
+func newSandbox() (*sandbox, error) {
+	root, err := os.MkdirTemp("", utils.PgmName+"-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create sandbox; %w", err)
+	}
+	s := &sandbox{root: root}
+	dirs := []string{s.workDir(), s.tmpDir()}
+	for _, x := range xdgDirs {
+		dirs = append(dirs, filepath.Join(s.homeDir(), x.dir))
+	}
+	for _, d := range dirs {
+		if err = os.MkdirAll(d, 0o700); err != nil {
+			s.remove(false)
+			return nil, fmt.Errorf("unable to create sandbox; %w", err)
+		}
+	}
+	return s, nil
+}
+
+// hermeticEnv returns the whitelisted part of the caller's environment,
+// plus variables pointing HOME, TMPDIR and the XDG dirs into the sandbox.
+func (s *sandbox) hermeticEnv() []string {
+	var result []string
+	for _, k := range hermeticVars {
+		if v, ok := os.LookupEnv(k); ok {
+			result = append(result, k+"="+v)
+		}
+	}
+	result = append(result, "HOME="+s.homeDir(), "TMPDIR="+s.tmpDir())
+	for _, x := range xdgDirs {
+		result = append(result, x.name+"="+filepath.Join(s.homeDir(), x.dir))
+	}
+	return result
+}
+
+// remove deletes the sandbox, unless asked to keep it.
+func (s *sandbox) remove(keep bool) {
+	if keep {
+		_, _ = fmt.Fprintf(os.Stderr, "sandbox kept at %s\n", s.root)
+		return
+	}
+	if err := os.RemoveAll(s.root); err != nil {
+		slog.Warn("unable to remove", "dir", s.root, "err", err)
+	}
+}
+
+// loadEnv returns the variables in the given file, followed by the
+// given variables, after checking that each has the form KEY=VALUE.
+// The file holds one variable per line; blank lines and lines
+// starting with # are ignored.
+func loadEnv(file string, vars []string) ([]string, error) {
+	var result []string
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err = checkEnvVar(line); err != nil {
+				return nil, fmt.Errorf("%s:%d; %w", file, n, err)
+			}
+			result = append(result, line)
+		}
+		if err = sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, kv := range vars {
+		if err := checkEnvVar(kv); err != nil {
+			return nil, err
+		}
+		result = append(result, kv)
+	}
+	return result, nil
+}
+
+func checkEnvVar(kv string) error {
+	if k, _, found := strings.Cut(kv, "="); !found || !loader.IsShellName(k) {
+		return fmt.Errorf("want KEY=VALUE, not %q", kv)
+	}
+	return nil
+}
+
+// missingEnv returns the variables that the front matter of the
+// block's file requires, but that the runner's shells won't have.
+func (rn *runner) missingEnv(b *loader.CodeBlock) (missing []string) {
+	if b.File() == nil || b.File().FrontMatter() == nil {
+		return nil
+	}
+	var set []string
+	if rn.flags.hermetic {
+		set = rn.sandbox.hermeticEnv()
+	}
+	set = append(set, rn.flags.env...)
+	for _, k := range b.File().FrontMatter().Env {
+		found := slices.ContainsFunc(set, func(kv string) bool {
+			return strings.HasPrefix(kv, k+"=")
+		})
+		if !found && !rn.flags.hermetic {
+			_, found = os.LookupEnv(k)
+		}
+		if !found {
+			missing = append(missing, k)
+		}
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHermetic(t *testing.T) {
+	t.Setenv("MDRIP_HOST_SECRET", "shh")
+	dir := writeFiles(t, map[string]string{
+		"a.md": "# A\n\n```bash\n" +
+			"echo \"secret=${MDRIP_HOST_SECRET-}\"\n" +
+			"echo \"injected=${INJECTED-}\"\n" +
+			"echo \"fromFile=${FROM_FILE-}\"\n" +
+			"echo \"home=$HOME\"\n" +
+			"echo \"pwd=$PWD\"\n" +
+			"```\n",
+		"env": "# comment\n\nFROM_FILE=yes\n",
+	})
+	envFile := filepath.Join(dir, "env")
+	for name, tc := range map[string]struct {
+		args []string
+		want []string
+		// sandboxed is true if HOME and the working dir are in a sandbox.
+		sandboxed bool
+	}{
+		"host": {
+			args: []string{"--env", "INJECTED=yes"},
+			want: []string{
+				"secret=shh", "injected=yes", "fromFile=",
+				"home=" + os.Getenv("HOME"),
+			},
+		},
+		"hermetic": {
+			args:      []string{"--hermetic", "--env", "INJECTED=yes", "--env-file", envFile},
+			want:      []string{"secret=", "injected=yes", "fromFile=yes"},
+			sandboxed: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			report := filepath.Join(t.TempDir(), "r.json")
+			_, errOut, err := runCommand(t, dir,
+				append(tc.args, "--report", "json:"+report, ".")...)
+			if !assert.NoError(t, err, errOut) {
+				t.FailNow()
+			}
+			out := readJsonReport(t, report)[0].Stdout
+			for _, w := range tc.want {
+				assert.Contains(t, out, w)
+			}
+			if !tc.sandboxed {
+				return
+			}
+			// The sandbox is removed afterward.
+			var home, pwd string
+			for _, line := range out {
+				if v, ok := strings.CutPrefix(line, "home="); ok {
+					home = v
+				}
+				if v, ok := strings.CutPrefix(line, "pwd="); ok {
+					pwd = v
+				}
+			}
+			assert.Equal(t, "home", filepath.Base(home))
+			assert.Equal(t, "work", filepath.Base(pwd))
+			assert.Equal(t, filepath.Dir(home), filepath.Dir(pwd))
+			assert.NoDirExists(t, filepath.Dir(home))
+		})
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	bad := filepath.Join(dir, "bad")
+	assert.NoError(t, os.WriteFile(good, []byte("# vars\nA=1\n\n  B=two words\n"), 0o644))
+	assert.NoError(t, os.WriteFile(bad, []byte("A=1\nnot a var\n"), 0o644))
+
+	env, err := loadEnv(good, []string{"C=", "D=x=y"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=1", "B=two words", "C=", "D=x=y"}, env)
+
+	_, err = loadEnv(bad, nil)
+	assert.ErrorContains(t, err, bad+":2")
+	_, err = loadEnv("", []string{"1A=b"})
+	assert.Error(t, err)
+	_, err = loadEnv("", []string{"noEquals"})
+	assert.Error(t, err)
+	_, err = loadEnv(filepath.Join(dir, "missing"), nil)
+	assert.Error(t, err)
+}
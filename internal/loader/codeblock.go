@@ -13,11 +13,31 @@ import (
 type CodeBlock struct {
 	// Labels on a block.  This is a list, rather than a set, because
 	// the first label might become the name of the block.
-	labels     LabelList
+	labels LabelList
+	// params are settings like timeouts, written as @name=value.
+	params     Params
 	titleWords []string
 	code       string
-	index      int
-	parent     *MyFile
+	// lang is the language named in the fence's info string,
+	// e.g. "bash" in "```bash".  Empty if the fence names no language.
+	lang string
+	// output, if not nil, holds the expected stdout of this block.
+	output *CodeBlock
+	// isOutput is true if this block is the output of some other block.
+	isOutput bool
+	// line is the one-relative line number, in the markdown file,
+	// of the block's first line of code.  Zero if unknown.
+	line int
+	// endLine is the line number of the block's last line of code.
+	endLine int
+	// column is the one-relative column, in bytes, at which
+	// the block's first line of code starts.
+	column int
+	// headings holds the text of the headings enclosing the block,
+	// outermost first, e.g. ["Install", "Linux", "From source"].
+	headings []string
+	index    int
+	parent   *MyFile
 }
 
 func NewCodeBlock(
@@ -27,6 +47,11 @@ func NewCodeBlock(
 	return b
 }
 
+// File is the file holding the block.
+func (cb *CodeBlock) File() *MyFile {
+	return cb.parent
+}
+
 // Path is the path to the file holding the block.
 func (cb *CodeBlock) Path() FilePath {
 	return cb.parent.Path()
@@ -35,6 +60,9 @@ func (cb *CodeBlock) Path() FilePath {
 const (
 	maxWordsInId = 4
 	maxWordSize  = 5
+	// Words in a heading are chosen by a person, so
+	// there's less need to shorten them.
+	maxHeadingWordSize = 10
 )
 
 // ResetTitle sets the title words for the block.
@@ -52,7 +80,13 @@ func (cb *CodeBlock) ResetTitle(disAmbig map[string]int) {
 	if len(normal) > 0 {
 		first = normal[0]
 		normal = normal[1:]
-	} else {
+	} else if len(cb.headings) > 0 {
+		// The innermost heading says more about the
+		// block than the block's code does.
+		first = lexer.MakeIdentifier(
+			cb.headings[len(cb.headings)-1], maxWordsInId, maxHeadingWordSize)
+	}
+	if first == "" {
 		first = lexer.MakeIdentifier(cb.code, maxWordsInId, maxWordSize)
 	}
 	if disAmbig != nil {
@@ -80,7 +114,15 @@ func (cb *CodeBlock) Title() string {
 // Equals is true if the block have the same content,
 // ignoring the parent.
 func (cb *CodeBlock) Equals(other *CodeBlock) bool {
-	return cb.code == other.code && cb.labels.Equals(other.labels)
+	return cb.code == other.code &&
+		cb.lang == other.lang &&
+		cb.labels.Equals(other.labels) &&
+		cb.params.Equals(other.params)
+}
+
+// Labels returns the block's labels, in the order found.
+func (cb *CodeBlock) Labels() LabelList {
+	return cb.labels
 }
 
 func (cb *CodeBlock) AddLabels(labels []Label) {
@@ -91,35 +133,165 @@ func (cb *CodeBlock) Code() string {
 	return cb.code
 }
 
+// Line is the one-relative line number, in the markdown file holding
+// the block, of the block's first line of code.  Zero if unknown.
+func (cb *CodeBlock) Line() int {
+	return cb.line
+}
+
+// EndLine is the line number, in the markdown file holding the
+// block, of the block's last line of code.  Zero if unknown.
+func (cb *CodeBlock) EndLine() int {
+	return cb.endLine
+}
+
+// Column is the one-relative column, in bytes, at which the
+// block's first line of code starts.  Zero if unknown.
+func (cb *CodeBlock) Column() int {
+	return cb.column
+}
+
+// SetPosition sets the lines of the block's first and last lines
+// of code, and the column of its first line.
+func (cb *CodeBlock) SetPosition(line, column, endLine int) {
+	cb.line = line
+	cb.column = column
+	cb.endLine = endLine
+}
+
+// Location is the block's path and line, as "path:line", for
+// editors and CI annotations that jump to a location.
+// Just the path if the line is unknown.
+func (cb *CodeBlock) Location() string {
+	if cb.line == 0 {
+		return string(cb.Path())
+	}
+	return string(cb.Path()) + ":" + strconv.Itoa(cb.line)
+}
+
+// Headings holds the text of the headings enclosing the
+// block, outermost first.
+func (cb *CodeBlock) Headings() []string {
+	return cb.headings
+}
+
+// SetHeadings sets the text of the headings enclosing the block.
+func (cb *CodeBlock) SetHeadings(headings []string) {
+	cb.headings = headings
+}
+
+// HeadingPath is the headings enclosing the block joined into
+// one string, e.g. "Install > Linux > From source".
+// Empty if the block precedes all headings.
+func (cb *CodeBlock) HeadingPath() string {
+	return strings.Join(cb.headings, headingSeparator)
+}
+
+const headingSeparator = " > "
+
+// Lang is the language declared in the block's fence, e.g. "bash".
+func (cb *CodeBlock) Lang() string {
+	return cb.lang
+}
+
+// SetLang sets the language of the block.
+func (cb *CodeBlock) SetLang(lang string) {
+	cb.lang = lang
+}
+
+// HasLang is true if the block's language matches one of the
+// arguments, ignoring case.
+func (cb *CodeBlock) HasLang(langs ...string) bool {
+	for _, l := range langs {
+		if strings.EqualFold(cb.lang, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpectedOutput returns the block holding the expected stdout
+// of this block, or nil if there's no such block.
+func (cb *CodeBlock) ExpectedOutput() *CodeBlock {
+	return cb.output
+}
+
+// SetExpectedOutput declares that the argument holds
+// the expected stdout of this block.
+func (cb *CodeBlock) SetExpectedOutput(output *CodeBlock) {
+	cb.output = output
+	output.isOutput = true
+}
+
+// IsExpectedOutput is true if this block holds the expected
+// output of some other block, and so should never be run.
+func (cb *CodeBlock) IsExpectedOutput() bool {
+	return cb.isOutput
+}
+
+// IsSetup is true if the block should run before the other
+// blocks in its file.
+func (cb *CodeBlock) IsSetup() bool {
+	return cb.HasLabel(SetupLabel)
+}
+
+// IsTeardown is true if the block should run after the other
+// blocks in its file, even if one of them failed.
+func (cb *CodeBlock) IsTeardown() bool {
+	return cb.HasLabel(TeardownLabel) || cb.HasLabel(CleanupLabel)
+}
+
 // HasLabel is true if the block has the given label argument.
 func (cb *CodeBlock) HasLabel(label Label) bool {
 	return cb.labels.Contains(label)
 }
 
+// PrintBlocks prints the blocks as a bash script, honoring
+// their dir, env and retry parameters.
 func PrintBlocks(wr io.Writer, blocks []*CodeBlock) {
 	f := fmt.Sprintf("%%d/%d %%s %%s\n", len(blocks))
 	for i, b := range blocks {
 		_, _ = fmt.Fprint(wr, "# ")
 		b.printTitle(wr, f, i+1)
 		_, _ = fmt.Fprintln(wr, "# ----------")
-		_, _ = fmt.Fprint(wr, b.code)
+		retries, _ := b.Retries()
+		_, _ = fmt.Fprint(wr, WrapRetries(b.Script(), retries))
 		_, _ = fmt.Fprintln(wr, "# ----------")
 		_, _ = fmt.Fprintln(wr)
 	}
 }
 
+// PrintTitles prints one line per block, showing the block's index,
+// path, language, title and, if any, the headings enclosing it.
 func PrintTitles(wr io.Writer, blocks []*CodeBlock) {
-	f := mkFormatTitleOnly(len(blocks))
+	langWidth := len(noLang)
+	for _, b := range blocks {
+		if len(b.lang) > langWidth {
+			langWidth = len(b.lang)
+		}
+	}
+	f := mkFormatTitleOnly(len(blocks), langWidth)
 	for i, b := range blocks {
-		b.printTitle(wr, f, i+1)
+		lang := b.lang
+		if lang == "" {
+			lang = noLang
+		}
+		title := b.Title()
+		if hp := b.HeadingPath(); hp != "" {
+			title += "  [" + hp + "]"
+		}
+		_, _ = fmt.Fprintf(wr, f, i+1, b.Location(), lang, title)
 	}
 }
 
-func mkFormatTitleOnly(n int) string {
+// noLang is displayed in place of an absent language.
+const noLang = "-"
+
+func mkFormatTitleOnly(n int, langWidth int) string {
 	width := len(strconv.Itoa(n))
-	return fmt.Sprintf("%%%dd/%d %%s %%s\n", width, n)
+	return fmt.Sprintf("%%%dd/%d %%s %%-%ds %%s\n", width, n, langWidth)
 }
 
 func (cb *CodeBlock) printTitle(wr io.Writer, f string, i int) {
-	_, _ = fmt.Fprintf(wr, f, i, cb.Path(), cb.Title())
+	_, _ = fmt.Fprintf(wr, f, i, cb.Location(), cb.Title())
 }
@@ -77,3 +77,38 @@ func Test_codeBlock_HasLabel(t *testing.T) {
 		})
 	}
 }
+
+func Test_codeBlock_ResetTitleFromHeadings(t *testing.T) {
+	disAmbig := make(map[string]int)
+	cb := NewCodeBlock(nil, "apt get meat ball", 0)
+	cb.SetHeadings([]string{"Install", "From source"})
+	cb.ResetTitle(disAmbig)
+	assert.Equal(t, "fromSource", cb.UniqName())
+	assert.Equal(t, "Install > From source", cb.HeadingPath())
+
+	cb = NewCodeBlock(nil, "apt get meat ball", 1, "protein")
+	cb.SetHeadings([]string{"Install", "From source"})
+	cb.ResetTitle(disAmbig)
+	assert.Equal(t, "protein", cb.UniqName())
+
+	cb = NewCodeBlock(nil, "apt get meat ball", 2)
+	cb.SetHeadings([]string{"Install", "From source"})
+	cb.ResetTitle(disAmbig)
+	assert.Equal(t, "fromSource2", cb.UniqName())
+
+	// A heading without usable words falls back to the code.
+	cb = NewCodeBlock(nil, "apt get meat ball", 3)
+	cb.SetHeadings([]string{"!!!"})
+	cb.ResetTitle(disAmbig)
+	assert.Equal(t, "aptGetMeatBall", cb.UniqName())
+}
+
+func Test_codeBlock_HasLang(t *testing.T) {
+	cb := NewCodeBlock(nil, "echo hi", 0)
+	assert.False(t, cb.HasLang("bash"))
+	assert.False(t, cb.HasLang())
+	cb.SetLang("Bash")
+	assert.Equal(t, "Bash", cb.Lang())
+	assert.True(t, cb.HasLang("shell", "bash"))
+	assert.False(t, cb.HasLang("yaml"))
+}
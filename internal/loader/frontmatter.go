@@ -0,0 +1,153 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter holds the settings found in the optional YAML
+// front matter at the top of a markdown file, e.g.
+//
+//	---
+//	title: Installing on Linux
+//	labels: [linux, install]
+//	env: [GITHUB_TOKEN]
+//	weight: 10
+//	timeout: 5m
+//	vars:
+//	  PROJECT_ID:
+//	  ZONE: us-central1-a
+//	---
+type FrontMatter struct {
+	// Title, if not empty, names the file in place of its file name.
+	Title string `yaml:"title"`
+	// Labels apply to every code block in the file.
+	Labels []string `yaml:"labels"`
+	// Skip, if true, labels every code block in the file with SkipLabel.
+	Skip bool `yaml:"skip"`
+	// Env names environment variables that the file's code blocks
+	// need; a test of the file is skipped if they aren't set.
+	Env []string `yaml:"env"`
+	// Weight orders the file among the other files in its folder,
+	// lightest first.  An ordering file takes precedence.
+	Weight int `yaml:"weight"`
+	// Shell is the bash compatible shell that runs the file's
+	// code blocks in tests, e.g. /usr/local/bin/bash.
+	Shell string `yaml:"shell"`
+	// Timeout is the default value of TimeoutParam for the
+	// file's code blocks.
+	Timeout string `yaml:"timeout"`
+	// Vars declares variables for substitution into the file's code
+	// blocks; see CodeBlock.WithVars.  A variable with an empty value
+	// must be given a value some other way, e.g. with a flag.
+	Vars Vars `yaml:"vars"`
+}
+
+// frontMatterFences open and close front matter.  YAML allows a
+// document to end with "..." as well as "---".
+var frontMatterFences = []string{"---", "..."}
+
+// ParseFrontMatter parses the front matter at the top of the content,
+// returning the front matter and the number of bytes it occupies,
+// including its fences.  The front matter is nil if there is none.
+// Text between fences that isn't a YAML mapping, e.g. markdown
+// between two thematic breaks, isn't front matter.
+func ParseFrontMatter(c []byte) (*FrontMatter, int, error) {
+	body, size := splitFrontMatter(c)
+	if size == 0 {
+		return nil, 0, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, 0, nil
+	}
+	var fm FrontMatter
+	if len(doc.Content) > 0 {
+		// Not empty.
+		if doc.Content[0].Kind != yaml.MappingNode {
+			return nil, 0, nil
+		}
+		if err := doc.Decode(&fm); err != nil {
+			return nil, 0, fmt.Errorf("bad front matter; %w", err)
+		}
+	}
+	if fm.Timeout != "" {
+		if d, err := time.ParseDuration(fm.Timeout); err != nil || d < 0 {
+			return nil, 0, fmt.Errorf(
+				"bad front matter; timeout %q isn't a duration like 90s", fm.Timeout)
+		}
+	}
+	for k := range fm.Vars {
+		if !IsVarName(k) {
+			return nil, 0, fmt.Errorf(
+				"bad front matter; var %q isn't a variable name", k)
+		}
+	}
+	for _, k := range fm.Env {
+		if !IsShellName(k) {
+			return nil, 0, fmt.Errorf(
+				"bad front matter; env %q isn't a variable name", k)
+		}
+	}
+	return &fm, size, nil
+}
+
+// splitFrontMatter returns the YAML between the front matter fences,
+// and the size of the front matter including the fences.
+// The size is zero if the content doesn't start with front matter.
+func splitFrontMatter(c []byte) (body []byte, size int) {
+	first, rest, ok := bytes.Cut(c, []byte("\n"))
+	if !ok || string(bytes.TrimRight(first, " \r")) != frontMatterFences[0] {
+		return nil, 0
+	}
+	offset := len(first) + 1
+	for len(rest) > 0 {
+		line, after, _ := bytes.Cut(rest, []byte("\n"))
+		end := offset + len(line)
+		if after != nil || end < len(c) {
+			// Include the newline.
+			end++
+		}
+		if slices.Contains(
+			frontMatterFences, string(bytes.TrimRight(line, " \r"))) {
+			return c[len(first)+1 : offset], end
+		}
+		offset = end
+		rest = after
+	}
+	// No closing fence, so not front matter.
+	return nil, 0
+}
+
+// BlockLabels returns the labels the front matter
+// applies to every code block in the file.
+func (fm *FrontMatter) BlockLabels() (result []Label) {
+	if fm == nil {
+		return nil
+	}
+	for _, l := range fm.Labels {
+		result = append(result, Label(strings.TrimPrefix(l, "@")))
+	}
+	if fm.Skip {
+		result = append(result, SkipLabel)
+	}
+	return
+}
+
+// BlockParams returns the params the front matter
+// applies to every code block in the file.
+func (fm *FrontMatter) BlockParams() Params {
+	if fm == nil {
+		return nil
+	}
+	p := make(Params)
+	if fm.Timeout != "" {
+		p.Add(TimeoutParam, fm.Timeout)
+	}
+	return p
+}
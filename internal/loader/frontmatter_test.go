@@ -0,0 +1,112 @@
+package loader_test
+
+import (
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	for n, tc := range map[string]struct {
+		content string
+		want    *FrontMatter
+		size    int
+		errMsg  string
+	}{
+		"none": {
+			content: "# hello\n",
+		},
+		"notAtTop": {
+			content: "\n---\ntitle: x\n---\n",
+		},
+		"unclosed": {
+			content: "---\ntitle: x\n",
+		},
+		"empty": {
+			content: "---\n---\n# hello\n",
+			want:    &FrontMatter{},
+			size:    8,
+		},
+		"full": {
+			content: `---
+title: Installing
+labels: [linux, "@install"]
+skip: true
+env: [HOME, GITHUB_TOKEN]
+weight: 3
+shell: /usr/local/bin/bash
+timeout: 5m
+vars:
+  PROJECT_ID:
+  ZONE: us-central1-a
+...
+# hello
+`,
+			want: &FrontMatter{
+				Title:   "Installing",
+				Labels:  []string{"linux", "@install"},
+				Skip:    true,
+				Env:     []string{"HOME", "GITHUB_TOKEN"},
+				Weight:  3,
+				Shell:   "/usr/local/bin/bash",
+				Timeout: "5m",
+				Vars:    Vars{"PROJECT_ID": "", "ZONE": "us-central1-a"},
+			},
+			size: 182,
+		},
+		"onlyFrontMatter": {
+			content: "---\ntitle: x\n---",
+			want:    &FrontMatter{Title: "x"},
+			size:    16,
+		},
+		"notYaml": {
+			content: "---\ntitle: [x\n---\n",
+		},
+		"thematicBreaks": {
+			content: "---\nSome text.\n---\n",
+		},
+		"notMapping": {
+			content: "---\n- a\n- b\n---\n",
+		},
+		"badType": {
+			content: "---\ntitle: [x]\n---\n",
+			errMsg:  "bad front matter",
+		},
+		"badTimeout": {
+			content: "---\ntimeout: soon\n---\n",
+			errMsg:  `timeout "soon" isn't a duration`,
+		},
+		"badVar": {
+			content: "---\nvars: {A.B: x}\n---\n",
+			errMsg:  `var "A.B" isn't a variable name`,
+		},
+		"badEnv": {
+			content: "---\nenv: [A-B]\n---\n",
+			errMsg:  `env "A-B" isn't a variable name`,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			fm, size, err := ParseFrontMatter([]byte(tc.content))
+			if tc.errMsg != "" {
+				assert.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, fm)
+			assert.Equal(t, tc.size, size)
+		})
+	}
+}
+
+func TestFrontMatterBlockSettings(t *testing.T) {
+	var fm *FrontMatter
+	assert.Empty(t, fm.BlockLabels())
+	assert.Empty(t, fm.BlockParams())
+
+	fm = &FrontMatter{
+		Labels: []string{"linux", "@install"}, Skip: true, Timeout: "5m"}
+	assert.Equal(t,
+		[]Label{"linux", "install", SkipLabel}, fm.BlockLabels())
+	assert.Equal(t, "5m", fm.BlockParams().Get(TimeoutParam))
+}
@@ -109,7 +109,8 @@ func (fsl *FsLoader) LoadOneTree(rawPath FilePath) (*MyFolder, error) {
 // If an "OrderingFileName" is found in a folder, it's used to sort the files
 // and sub-folders in that folder's in-memory representation. An ordering file
 // is just lines of text, one name per line. Ordered files appear first, with
-// the remainder in the order imposed by fs.ReadDir.
+// the remainder sorted by the weight in their front matter, and otherwise
+// in the order imposed by fs.ReadDir.
 //
 // Any error returned will be from the file system.
 func (fsl *FsLoader) LoadFolder(rawPath FilePath) (*MyFolder, error) {
@@ -231,7 +232,7 @@ func (fsl *FsLoader) loadFolder(path string) (*MyFolder, error) {
 	if result.IsEmpty() {
 		return nil, nil
 	}
-	result.files = ReorderFiles(result.files, ordering)
+	result.files = ReorderFiles(SortFilesByWeight(result.files), ordering)
 	result.dirs = ReorderFolders(result.dirs, ordering)
 	return &result, nil
 }
@@ -63,6 +63,11 @@ func init() {
 	}
 }
 
+var (
+	heavyMd = NewFile("heavy.md", []byte("---\nweight: 10\n---\n# heavy\n"))
+	lightMd = NewFile("light.md", []byte("---\nweight: -3\n---\n# light\n"))
+)
+
 func makeSmallAbsFs(t *testing.T, fs afero.Fs) {
 	assert.NoError(t, afero.WriteFile(fs, "/f00.md", md[0].C(), RW))
 	assert.NoError(t, afero.WriteFile(fs, "/aaa/f01.md", md[1].C(), RW))
@@ -316,6 +321,20 @@ func TestLoadFolderFromMemoryHappy(t *testing.T) {
 				return NewFolder("/").AddFile(md[10]).AddFolder(jjj).AddFolder(mmm)
 			},
 		},
+		"weightedFiles": {
+			fillFs: func(tt *testing.T, fs afero.Fs) {
+				assert.NoError(tt, afero.WriteFile(fs, "/a.md", heavyMd.C(), RW))
+				assert.NoError(tt, afero.WriteFile(fs, "/b.md", md[0].C(), RW))
+				assert.NoError(tt, afero.WriteFile(fs, "/c.md", lightMd.C(), RW))
+			},
+			pathToLoad: "/",
+			expectedFld: func() *MyFolder {
+				return NewFolder("/").
+					AddFile(NewFile("c.md", lightMd.C())).
+					AddFile(NewFile("b.md", md[0].C())).
+					AddFile(NewFile("a.md", heavyMd.C()))
+			},
+		},
 	} {
 		t.Run(n, func(t *testing.T) {
 			fs := afero.NewMemMapFs() // afero.NewOsFs()
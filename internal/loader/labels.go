@@ -14,6 +14,30 @@ const (
 
 	// SkipLabel is used on blocks that should be skipped in some context.
 	SkipLabel = Label(`skip`)
+
+	// ExpectLabel marks a block holding the expected stdout of the
+	// code block that precedes it in the same file.
+	ExpectLabel = Label(`expect`)
+
+	// SetupLabel marks a block that runs before the other blocks in
+	// its file, even if a label filter would exclude it.
+	SetupLabel = Label(`setup`)
+
+	// TeardownLabel marks a block that runs after the other blocks in
+	// its file, even if a label filter would exclude it, and even if
+	// an earlier block failed.
+	TeardownLabel = Label(`teardown`)
+
+	// CleanupLabel is a synonym for TeardownLabel.
+	CleanupLabel = Label(`cleanup`)
+
+	// BackgroundLabel marks a block, e.g. one starting a server, that
+	// should run in the background while later blocks run.
+	BackgroundLabel = Label(`background`)
+
+	// AppendLabel marks a block with a FileParam whose code should be
+	// appended to the file, rather than replace the file's content.
+	AppendLabel = Label(`append`)
 )
 
 type LabelList []Label
@@ -36,7 +60,18 @@ func (lst LabelList) Contains(l Label) bool {
 }
 
 func (l Label) IsSpecial() bool {
-	return l == SleepLabel || l == SkipLabel
+	return l == SleepLabel || l == SkipLabel || l == ExpectLabel ||
+		l == SetupLabel || l == TeardownLabel || l == CleanupLabel ||
+		l == BackgroundLabel || l == AppendLabel
+}
+
+// Strings returns the labels as strings.
+func (lst LabelList) Strings() []string {
+	result := make([]string, len(lst))
+	for i := range lst {
+		result[i] = string(lst[i])
+	}
+	return result
 }
 
 // Equals is true if the slices have the same contents, ordering irrelevant.
@@ -4,6 +4,13 @@ package loader
 type MyFile struct {
 	myTreeNode
 	content []byte
+	// frontMatter is set by whatever parses the content.
+	frontMatter *FrontMatter
+	// fmSize and fmErr are the rest of what ParseFrontMatter
+	// returned, if fmParsed is true.
+	fmSize   int
+	fmErr    error
+	fmParsed bool
 }
 
 var _ MyTreeNode = &MyFile{}
@@ -26,6 +33,7 @@ func (fi *MyFile) Accept(v TreeVisitor) {
 // Load loads the file contents into the file object.
 func (fi *MyFile) Load(fsl *FsLoader) (err error) {
 	fi.content, err = fsl.fs.ReadFile(string(fi.Path()))
+	fi.fmParsed = false
 	return
 }
 
@@ -55,3 +63,36 @@ func (fi *MyFile) Equals(other *MyFile) bool {
 	}
 	return true
 }
+
+// FrontMatter is the file's front matter, or nil if
+// the file has none, or hasn't been parsed.
+func (fi *MyFile) FrontMatter() *FrontMatter {
+	return fi.frontMatter
+}
+
+// ParseFrontMatter parses the front matter at the top of the file's
+// contents, as the function ParseFrontMatter does, and sets the
+// file's front matter.  The content is parsed only once; later calls,
+// e.g. to sort files by weight and then to render them, return
+// the same results.
+func (fi *MyFile) ParseFrontMatter() (*FrontMatter, int, error) {
+	if !fi.fmParsed {
+		fi.frontMatter, fi.fmSize, fi.fmErr = ParseFrontMatter(fi.content)
+		fi.fmParsed = true
+	}
+	return fi.frontMatter, fi.fmSize, fi.fmErr
+}
+
+// SetFrontMatter sets the file's front matter.
+func (fi *MyFile) SetFrontMatter(fm *FrontMatter) {
+	fi.frontMatter = fm
+}
+
+// Title is the title from the file's front matter,
+// or, failing that, the file's name.
+func (fi *MyFile) Title() string {
+	if fi.frontMatter != nil && fi.frontMatter.Title != "" {
+		return fi.frontMatter.Title
+	}
+	return fi.Name()
+}
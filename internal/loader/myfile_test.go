@@ -22,6 +22,18 @@ func TestMyFileEqualsFull(t *testing.T) {
 	assert.False(t, f1.Equals(f2))
 }
 
+func TestMyFileParseFrontMatter(t *testing.T) {
+	f := NewFile("f.md", []byte("---\nweight: 2\n---\n# hello\n"))
+	assert.Nil(t, f.FrontMatter())
+	fm, size, err := f.ParseFrontMatter()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fm.Weight)
+	assert.Equal(t, 18, size)
+	assert.Same(t, fm, f.FrontMatter())
+	again, _, _ := f.ParseFrontMatter()
+	assert.Same(t, fm, again)
+}
+
 func TestClean(t *testing.T) {
 	// Just documenting behavior
 	assert.Equal(t, ".", filepath.Clean(".///"))
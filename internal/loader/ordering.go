@@ -2,6 +2,7 @@ package loader
 
 import (
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/spf13/afero"
@@ -48,6 +49,22 @@ func shiftFolderToTop(x []*MyFolder, top string) []*MyFolder {
 	return append(first, remainder...)
 }
 
+// SortFilesByWeight stably sorts the files by the weight in their
+// front matter, lightest first.  A file without front matter, or
+// with bad front matter, weighs zero.
+func SortFilesByWeight(x []*MyFile) []*MyFile {
+	weight := make(map[*MyFile]int, len(x))
+	for _, f := range x {
+		if fm, _, err := f.ParseFrontMatter(); err == nil && fm != nil {
+			weight[f] = fm.Weight
+		}
+	}
+	slices.SortStableFunc(x, func(a, b *MyFile) int {
+		return weight[a] - weight[b]
+	})
+	return x
+}
+
 func ReorderFiles(x []*MyFile, ordering []string) []*MyFile {
 	for i := len(ordering) - 1; i >= 0; i-- {
 		x = shiftFileToTop(x, ordering[i])
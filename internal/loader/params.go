@@ -0,0 +1,292 @@
+package loader
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ParamName names a code block parameter.
+//
+// Parameters are written like labels, but with a value, e.g.
+//
+//	<!-- @install @timeout=90s @env=FOO=bar -->
+type ParamName string
+
+// String form of the parameter name.
+func (n ParamName) String() string { return string(n) }
+
+// paramSeparator separates a parameter's name from its value.
+const paramSeparator = "="
+
+const (
+	// TimeoutParam is the max time to let the block run, e.g. @timeout=90s.
+	TimeoutParam = ParamName(`timeout`)
+
+	// RetryParam is how many times to retry a failing block, e.g. @retry=3.
+	RetryParam = ParamName(`retry`)
+
+	// DirParam is the directory in which to run the block, e.g. @dir=/tmp/x.
+	DirParam = ParamName(`dir`)
+
+	// EnvParam is an environment variable to export before running
+	// the block, e.g. @env=FOO=bar.  It may be repeated.
+	EnvParam = ParamName(`env`)
+
+	// ExpectParam marks a block holding expected output (like ExpectLabel),
+	// and names how to match it, e.g. @expect=regex.
+	ExpectParam = ParamName(`expect`)
+
+	// SleepParam is how long to sleep after the block, e.g. @sleep=5s.
+	// It overrides the test command's default for SleepLabel.
+	SleepParam = ParamName(`sleep`)
+
+	// WaitForParam is a condition to wait for after the block runs,
+	// e.g. @waitfor=port:8080.  It may be repeated.
+	WaitForParam = ParamName(`waitfor`)
+
+	// WaitTimeoutParam is the max time to wait for the block's
+	// WaitForParam conditions, e.g. @waittimeout=2m.
+	WaitTimeoutParam = ParamName(`waittimeout`)
+
+	// StopParam names a block labelled with BackgroundLabel whose
+	// processes should be stopped before this block runs,
+	// e.g. @stop=server.  It may be repeated.
+	StopParam = ParamName(`stop`)
+
+	// SessionParam names the shell session in which to run the block,
+	// e.g. @session=client, for tutorials that say "in a second
+	// terminal, run...".  Blocks without it run in a default session.
+	SessionParam = ParamName(`session`)
+
+	// NeedsParam names a block that must run before this block, e.g.
+	// @needs=install or @needs=setup.md#install for a block in
+	// another file.  It may be repeated.
+	NeedsParam = ParamName(`needs`)
+
+	// FileParam names a file that the block's code should be written
+	// to, rather than run, e.g. @file=cmd/main.go.  A relative path is
+	// relative to the block's working directory, or, when tangling,
+	// to the output directory.
+	FileParam = ParamName(`file`)
+
+	// ModeParam is the octal permission of the file named by FileParam,
+	// e.g. @mode=0755.  The default is 0644.
+	ModeParam = ParamName(`mode`)
+)
+
+// Params maps parameter names to values.  A name may have
+// more than one value, e.g. several env settings.
+type Params map[ParamName][]string
+
+// Add adds a value to the parameter.
+func (p Params) Add(n ParamName, v string) {
+	p[n] = append(p[n], v)
+}
+
+// Has is true if the parameter has at least one value.
+func (p Params) Has(n ParamName) bool {
+	return len(p[n]) > 0
+}
+
+// Get returns the last value of the parameter, or an empty string.
+func (p Params) Get(n ParamName) string {
+	if v := p[n]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return ""
+}
+
+// Values returns all the values of the parameter.
+func (p Params) Values(n ParamName) []string {
+	return p[n]
+}
+
+// Equals is true if the params have the same contents.
+func (p Params) Equals(other Params) bool {
+	return maps.EqualFunc(p, other, slices.Equal)
+}
+
+// String returns the params in their label form, sorted by name.
+func (p Params) String() string {
+	var words []string
+	for _, n := range slices.Sorted(maps.Keys(p)) {
+		for _, v := range p[n] {
+			if strings.ContainsRune(v, ' ') {
+				v = `"` + v + `"`
+			}
+			words = append(words,
+				string(labelPrefixChar)+string(n)+paramSeparator+v)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Params returns all the parameters on the block.
+func (cb *CodeBlock) Params() Params {
+	return cb.params
+}
+
+// AddParams adds parameters to the block.
+func (cb *CodeBlock) AddParams(p Params) {
+	if len(p) == 0 {
+		return
+	}
+	if cb.params == nil {
+		cb.params = make(Params)
+	}
+	for n, values := range p {
+		for _, v := range values {
+			cb.params.Add(n, v)
+		}
+	}
+}
+
+// HasParam is true if the block has a value for the given parameter.
+func (cb *CodeBlock) HasParam(n ParamName) bool {
+	return cb.params.Has(n)
+}
+
+// Timeout is the max time the block should be allowed to run,
+// or zero if the block doesn't say.
+func (cb *CodeBlock) Timeout() (time.Duration, error) {
+	return cb.durationParam(TimeoutParam)
+}
+
+// Sleep is how long to sleep after the block runs,
+// or zero if the block doesn't say.
+func (cb *CodeBlock) Sleep() (time.Duration, error) {
+	return cb.durationParam(SleepParam)
+}
+
+// WaitTimeout is the max time to wait for the block's wait
+// conditions, or zero if the block doesn't say.
+func (cb *CodeBlock) WaitTimeout() (time.Duration, error) {
+	return cb.durationParam(WaitTimeoutParam)
+}
+
+func (cb *CodeBlock) durationParam(n ParamName) (time.Duration, error) {
+	if !cb.params.Has(n) {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(cb.params.Get(n))
+	if err != nil || d < 0 {
+		return 0, cb.paramErr(n, "want a duration like 90s")
+	}
+	return d, nil
+}
+
+// Retries is how many times a failing block should be retried.
+func (cb *CodeBlock) Retries() (int, error) {
+	if !cb.params.Has(RetryParam) {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(cb.params.Get(RetryParam))
+	if err != nil || n < 0 {
+		return 0, cb.paramErr(RetryParam, "want a non-negative integer")
+	}
+	return n, nil
+}
+
+// Dir is the directory in which to run the block,
+// or an empty string if the block doesn't say.
+func (cb *CodeBlock) Dir() string {
+	return cb.params.Get(DirParam)
+}
+
+// Session is the name of the shell session in which to run the block,
+// or an empty string for the default session.
+func (cb *CodeBlock) Session() string {
+	return cb.params.Get(SessionParam)
+}
+
+// validateSession returns an error if the session name would be
+// awkward as, say, a tmux window name.
+func (cb *CodeBlock) validateSession() error {
+	if !cb.params.Has(SessionParam) {
+		return nil
+	}
+	s := cb.Session()
+	for _, r := range s {
+		if r != '_' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return cb.paramErr(SessionParam, "want letters, digits, - or _")
+		}
+	}
+	if s == "" {
+		return cb.paramErr(SessionParam, "want a name")
+	}
+	return nil
+}
+
+// Needs returns references to the blocks that must run before this
+// block.  Each is a block name, optionally preceded by a file
+// path and #.
+func (cb *CodeBlock) Needs() []string {
+	return cb.params.Values(NeedsParam)
+}
+
+// Env returns the KEY=VALUE environment settings of the block.
+func (cb *CodeBlock) Env() ([]string, error) {
+	result := cb.params.Values(EnvParam)
+	for _, kv := range result {
+		if k, _, found := strings.Cut(kv, paramSeparator); !found || !IsShellName(k) {
+			return nil, cb.paramErr(EnvParam, "want KEY=VALUE, not "+kv)
+		}
+	}
+	return result, nil
+}
+
+// ValidateParams returns an error if any of the block's well-known
+// parameters have a bad value.
+func (cb *CodeBlock) ValidateParams() error {
+	if _, err := cb.Timeout(); err != nil {
+		return err
+	}
+	if _, err := cb.Retries(); err != nil {
+		return err
+	}
+	if _, err := cb.Sleep(); err != nil {
+		return err
+	}
+	if _, err := cb.WaitTimeout(); err != nil {
+		return err
+	}
+	if _, err := cb.WaitFor(); err != nil {
+		return err
+	}
+	if err := cb.validateSession(); err != nil {
+		return err
+	}
+	if _, err := cb.FileMode(); err != nil {
+		return err
+	}
+	if err := cb.validateTangleFile(); err != nil {
+		return err
+	}
+	_, err := cb.Env()
+	return err
+}
+
+func (cb *CodeBlock) paramErr(n ParamName, msg string) error {
+	return fmt.Errorf(
+		"bad @%s%s%s; %s", n, paramSeparator, cb.params.Get(n), msg)
+}
+
+// IsShellName is true if the argument can name a shell variable.
+func IsShellName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
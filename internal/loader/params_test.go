@@ -0,0 +1,91 @@
+package loader_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeBlockParams(t *testing.T) {
+	cb := NewCodeBlock(nil, "echo $FOO\n", 0)
+	d, err := cb.Timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d)
+	assert.Equal(t, "echo $FOO\n", cb.Script())
+
+	cb.AddParams(ParseParams(
+		`@timeout=90s @retry=2 @dir=/tmp/x @env=FOO=bar @env=B="it's"`))
+	assert.NoError(t, cb.ValidateParams())
+	d, err = cb.Timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+	n, err := cb.Retries()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "/tmp/x", cb.Dir())
+	assert.Equal(t, "", cb.Session())
+	assert.Equal(t, `export FOO='bar'
+export B='it'\''s'
+mdripOldDir="$PWD"
+cd '/tmp/x'
+echo $FOO
+cd "$mdripOldDir"
+`, cb.Script())
+	assert.Equal(t,
+		`@dir=/tmp/x @env=FOO=bar @env=B=it's @retry=2 @timeout=90s`,
+		cb.Params().String())
+}
+
+func TestCodeBlockBadParams(t *testing.T) {
+	for name, arg := range map[string]string{
+		"timeout":  "@timeout=soon",
+		"retry":    "@retry=-1",
+		"env":      "@env=1A=b",
+		"sleep":    "@sleep=forever",
+		"waitKind": "@waitfor=socket:/x",
+		"waitPort": "@waitfor=port:http",
+		"waitArg":  "@waitfor=file",
+		"session":  "@session=a:b",
+		"mode":     "@file=x @mode=rwx",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cb := NewCodeBlock(nil, "echo hi\n", 0)
+			cb.AddParams(ParseParams(arg))
+			assert.Error(t, cb.ValidateParams())
+		})
+	}
+}
+
+func TestCodeBlockWaitParams(t *testing.T) {
+	cb := NewCodeBlock(nil, "server &\n", 0)
+	cb.AddParams(ParseParams(
+		`@sleep=2s @waittimeout=1m @waitfor=port:8080 ` +
+			`@waitfor=port:example.com:443 @waitfor=file:/tmp/ready ` +
+			`@waitfor=cmd:"curl -sf localhost"`))
+	assert.NoError(t, cb.ValidateParams())
+	d, err := cb.Sleep()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, d)
+	d, err = cb.WaitTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, d)
+	conds, err := cb.WaitFor()
+	assert.NoError(t, err)
+	if !assert.Equal(t, 4, len(conds)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "(: </dev/tcp/localhost/8080) 2>/dev/null", conds[0].Test())
+	assert.Equal(t, "(: </dev/tcp/example.com/443) 2>/dev/null", conds[1].Test())
+	assert.Equal(t, "[ -e '/tmp/ready' ]", conds[2].Test())
+	assert.Equal(t, "(eval 'curl -sf localhost') >/dev/null 2>&1", conds[3].Test())
+	assert.Equal(t, "cmd:curl -sf localhost", conds[3].String())
+}
+
+func TestCodeBlockSession(t *testing.T) {
+	cb := NewCodeBlock(nil, "echo hi\n", 0)
+	cb.AddParams(ParseParams(`@session=client-2`))
+	assert.NoError(t, cb.ValidateParams())
+	assert.Equal(t, "client-2", cb.Session())
+}
@@ -0,0 +1,96 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Script returns the block's body as a bash script that honors
+// the block's dir and env parameters.
+//
+// Environment variables are exported before the body runs, and
+// remain set afterward.  The working directory is restored after
+// the body runs.  A block without such parameters yields its body
+// unchanged.
+func (cb *CodeBlock) Script() string {
+	prologue, epilogue := cb.ScriptParts()
+	return prologue + cb.Body() + epilogue
+}
+
+// Body returns the shell commands that do what the block says.
+// That's the block's code, unless the block has a FileParam, in
+// which case it's commands writing the code to the file.
+func (cb *CodeBlock) Body() string {
+	if !cb.IsFile() {
+		return cb.code
+	}
+	path := ShellQuote(cb.TangleFile())
+	redirect := ">"
+	if cb.HasLabel(AppendLabel) {
+		redirect = ">>"
+	}
+	body := "mkdir -p -- \"$(dirname -- " + path + ")\"\n" +
+		"printf '%s' " + ShellQuote(cb.code) + " " + redirect + " " + path + "\n"
+	if !cb.HasParam(ModeParam) {
+		return body
+	}
+	mode, _ := cb.FileMode()
+	return body + fmt.Sprintf("chmod %o %s\n", mode, path)
+}
+
+// ScriptParts returns the shell commands that Script puts before and
+// after the block's body.  The prologue is empty or ends with a
+// newline.  If the body doesn't end with a newline, the epilogue
+// begins with one.
+func (cb *CodeBlock) ScriptParts() (prologue, epilogue string) {
+	var b strings.Builder
+	env, _ := cb.Env()
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, paramSeparator)
+		b.WriteString("export " + k + "=" + ShellQuote(v) + "\n")
+	}
+	dir := cb.Dir()
+	if dir != "" {
+		b.WriteString("mdripOldDir=\"$PWD\"\n")
+		b.WriteString("cd " + ShellQuote(dir) + "\n")
+	}
+	prologue = b.String()
+	b.Reset()
+	if !strings.HasSuffix(cb.Body(), "\n") {
+		b.WriteString("\n")
+	}
+	if dir != "" {
+		b.WriteString("cd \"$mdripOldDir\"\n")
+	}
+	epilogue = b.String()
+	return
+}
+
+// WrapRetries wraps the script so that it runs in a subshell with
+// errexit set, and if it fails, is retried up to the given number
+// of times.  The wrapper's exit status is that of the last attempt.
+// Because of the subshell, changes the script makes to variables or
+// the working directory don't survive.
+func WrapRetries(script string, retries int) string {
+	if retries < 1 {
+		return script
+	}
+	return fmt.Sprintf(`mdripOpts=$-
+set +e
+for mdripTry in $(seq 0 %d); do
+(
+set -e
+%s)
+mdripRc=$?
+if [ $mdripRc -eq 0 ]; then break; fi
+done
+case $mdripOpts in *e*) set -e ;; esac
+(exit $mdripRc)
+`, retries, script)
+}
+
+// ShellQuote returns the argument in single quotes,
+// so that a shell will treat it as one literal word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
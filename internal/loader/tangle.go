@@ -0,0 +1,252 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultFileMode is the permission of a file written from a block
+// that has no ModeParam.
+const defaultFileMode = os.FileMode(0o644)
+
+// chunkRefRe matches a noweb style chunk reference, like <<imports>>,
+// alone on its line.  The indentation before the reference is kept.
+var chunkRefRe = regexp.MustCompile(`(?m)^([ \t]*)<<([A-Za-z0-9_.-]+)>>[ \t]*$`)
+
+// IsFile is true if the block's code should be written to a file
+// rather than run.
+func (cb *CodeBlock) IsFile() bool {
+	return cb.params.Has(FileParam)
+}
+
+// TangleFile is the path of the file that the block's code should
+// be written to, or an empty string if the block doesn't say.
+func (cb *CodeBlock) TangleFile() string {
+	return cb.params.Get(FileParam)
+}
+
+// validateTangleFile returns an error if the block's FileParam,
+// if any, isn't a relative path staying below the directory it's
+// relative to.
+func (cb *CodeBlock) validateTangleFile() error {
+	if !cb.IsFile() {
+		return nil
+	}
+	_, err := tanglePath("", cb.TangleFile())
+	return err
+}
+
+// FileMode is the permission of the file named by the block's FileParam.
+func (cb *CodeBlock) FileMode() (os.FileMode, error) {
+	if !cb.params.Has(ModeParam) {
+		return defaultFileMode, nil
+	}
+	n, err := strconv.ParseUint(cb.params.Get(ModeParam), 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, cb.paramErr(ModeParam, "want an octal permission like 0755")
+	}
+	return os.FileMode(n), nil
+}
+
+// ExpandChunks returns the blocks with chunk references, like
+// <<imports>>, in the code of file blocks replaced by the code of
+// the referenced blocks, recursively.  Blocks are found among all
+// by UniqName, preferring a block in the same file as the referring
+// block.  A block without a FileParam is returned as is.
+func ExpandChunks(blocks, all []*CodeBlock) ([]*CodeBlock, error) {
+	result := make([]*CodeBlock, len(blocks))
+	for i, b := range blocks {
+		if !b.IsFile() {
+			result[i] = b
+			continue
+		}
+		code, err := expandChunks(b, all, []string{b.UniqName()})
+		if err != nil {
+			return nil, fmt.Errorf("block %q at %s; %w", b.UniqName(), b.Location(), err)
+		}
+		if code == b.code {
+			result[i] = b
+			continue
+		}
+		c := *b
+		c.code = code
+		result[i] = &c
+	}
+	return result, nil
+}
+
+func expandChunks(b *CodeBlock, all []*CodeBlock, path []string) (string, error) {
+	var err error
+	code := chunkRefRe.ReplaceAllStringFunc(b.code, func(m string) string {
+		if err != nil {
+			return m
+		}
+		sub := chunkRefRe.FindStringSubmatch(m)
+		indent, name := sub[1], sub[2]
+		for _, n := range path {
+			if n == name {
+				err = fmt.Errorf(
+					"chunk references form a cycle: %s",
+					strings.Join(append(path, name), " -> "))
+				return m
+			}
+		}
+		var chunk *CodeBlock
+		if chunk, err = findChunk(b, all, name); err != nil {
+			return m
+		}
+		var text string
+		if text, err = expandChunks(chunk, all, append(path, name)); err != nil {
+			return m
+		}
+		return indentLines(strings.TrimSuffix(text, "\n"), indent)
+	})
+	return code, err
+}
+
+// ChunksOnly returns the blocks that file blocks refer to as chunks,
+// directly or through other chunks, and that aren't file blocks
+// themselves.  Such blocks hold parts of files, not commands to run.
+// Bad references are ignored here; ExpandChunks reports them.
+func ChunksOnly(all []*CodeBlock) map[*CodeBlock]bool {
+	result := make(map[*CodeBlock]bool)
+	var visit func(b *CodeBlock)
+	visit = func(b *CodeBlock) {
+		for _, sub := range chunkRefRe.FindAllStringSubmatch(b.code, -1) {
+			chunk, err := findChunk(b, all, sub[2])
+			if err != nil || chunk.IsFile() || result[chunk] {
+				continue
+			}
+			result[chunk] = true
+			visit(chunk)
+		}
+	}
+	for _, b := range all {
+		if b.IsFile() {
+			visit(b)
+		}
+	}
+	return result
+}
+
+// findChunk returns the block with the given name, preferring
+// one in the same file as the referring block.
+func findChunk(from *CodeBlock, all []*CodeBlock, name string) (*CodeBlock, error) {
+	var found []*CodeBlock
+	for _, b := range all {
+		if b.UniqName() != name {
+			continue
+		}
+		if b.parent == from.parent {
+			return b, nil
+		}
+		found = append(found, b)
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no block named %q for <<%s>>", name, name)
+	case 1:
+		return found[0], nil
+	}
+	var locs []string
+	for _, b := range found {
+		locs = append(locs, b.Location())
+	}
+	return nil, fmt.Errorf(
+		"<<%s>> is ambiguous; blocks at %s have that name",
+		name, strings.Join(locs, ", "))
+}
+
+// indentLines prefixes each non-empty line of s with the indent.
+func indentLines(s, indent string) string {
+	if indent == "" {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = indent + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Tangle writes the code of the file blocks, in order, to their files
+// below outDir.  A block labelled AppendLabel appends to its file;
+// otherwise the block replaces the file's content.  It returns the
+// paths of the files written, in the order first written.
+func Tangle(blocks []*CodeBlock, outDir string) ([]string, error) {
+	var written []string
+	seen := make(map[string]bool)
+	for _, b := range blocks {
+		if !b.IsFile() {
+			continue
+		}
+		path, err := tanglePath(outDir, b.TangleFile())
+		if err != nil {
+			return written, fmt.Errorf(
+				"block %q at %s; %w", b.UniqName(), b.Location(), err)
+		}
+		mode, err := b.FileMode()
+		if err != nil {
+			return written, fmt.Errorf(
+				"block %q at %s; %w", b.UniqName(), b.Location(), err)
+		}
+		if err = writeBlockFile(
+			path, b.code, mode, b.HasParam(ModeParam),
+			b.HasLabel(AppendLabel)); err != nil {
+			return written, err
+		}
+		if !seen[path] {
+			seen[path] = true
+			written = append(written, path)
+		}
+	}
+	return written, nil
+}
+
+// tanglePath joins outDir and the relative path of a file block,
+// refusing paths that would land outside outDir.
+func tanglePath(outDir, file string) (string, error) {
+	if file == "" || filepath.IsAbs(file) {
+		return "", fmt.Errorf("want a relative @%s path, not %q", FileParam, file)
+	}
+	clean := filepath.Clean(filepath.FromSlash(file))
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf(
+			"@%s path %q leaves the directory it's relative to", FileParam, file)
+	}
+	return filepath.Join(outDir, clean), nil
+}
+
+// writeBlockFile writes the code to the file at path, creating it
+// with the given mode.  An existing file keeps its mode, unless
+// setMode is true.
+func writeBlockFile(
+	path, code string, mode os.FileMode, setMode, appending bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appending {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(code); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil || !setMode {
+		return err
+	}
+	// OpenFile doesn't change the mode of an existing file,
+	// and the umask may have trimmed it.
+	return os.Chmod(path, mode)
+}
@@ -0,0 +1,140 @@
+package loader_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFileBlock(fi *MyFile, code string, i int, params string, labels ...Label) *CodeBlock {
+	cb := NewCodeBlock(fi, code, i, labels...)
+	cb.AddParams(ParseParams(params))
+	cb.ResetTitle(nil)
+	return cb
+}
+
+func TestFileBlockScript(t *testing.T) {
+	cb := newFileBlock(nil, "echo 'hi'\n", 0, "@file=bin/hi.sh @mode=0755")
+	assert.NoError(t, cb.ValidateParams())
+	assert.True(t, cb.IsFile())
+	assert.Equal(t, `mkdir -p -- "$(dirname -- 'bin/hi.sh')"
+printf '%s' 'echo '\''hi'\''
+' > 'bin/hi.sh'
+chmod 755 'bin/hi.sh'
+`, cb.Script())
+
+	// Without @mode, the file keeps its mode.
+	cb = newFileBlock(nil, "b\n", 0, "@file=notes", AppendLabel)
+	assert.True(t, strings.HasSuffix(cb.Script(), "printf '%s' 'b\n' >> 'notes'\n"))
+	assert.NotContains(t, cb.Script(), "chmod")
+
+	for _, bad := range []string{"@file=../x", "@file=a/../../x", "@file=/etc/x"} {
+		assert.Error(t, newFileBlock(nil, "x\n", 0, bad).ValidateParams(), bad)
+	}
+}
+
+func TestChunksOnly(t *testing.T) {
+	fi := NewFile("tangle.md", nil)
+	main := newFileBlock(fi, "<<body>>\n<<helper>>\n", 0, "@file=main.go", "main")
+	body := NewCodeBlock(fi, "<<inner>>\n", 1, "body")
+	body.ResetTitle(nil)
+	inner := NewCodeBlock(fi, "x\n", 2, "inner")
+	inner.ResetTitle(nil)
+	helper := newFileBlock(fi, "y\n", 3, "@file=helper.go", "helper")
+	cmd := NewCodeBlock(fi, "go run .\n", 4, "run")
+	cmd.ResetTitle(nil)
+	assert.Equal(t,
+		map[*CodeBlock]bool{body: true, inner: true},
+		ChunksOnly([]*CodeBlock{main, body, inner, helper, cmd}))
+}
+
+func TestExpandChunks(t *testing.T) {
+	fi := NewFile("tangle.md", nil)
+	main := newFileBlock(fi, `package main
+
+import (
+	<<imports>>
+)
+
+func main() {
+	<<body>>
+}
+`, 0, "@file=main.go", "main")
+	imports := NewCodeBlock(fi, "\"fmt\"\n\"os\"\n", 1, "imports", SkipLabel)
+	imports.ResetTitle(nil)
+	body := NewCodeBlock(fi, "fmt.Println(\"hi\")\n\nos.Exit(0)\n", 2, "body")
+	body.ResetTitle(nil)
+	all := []*CodeBlock{main, imports, body}
+
+	blocks, err := ExpandChunks([]*CodeBlock{main, body}, all)
+	assert.NoError(t, err)
+	assert.Equal(t, `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("hi")
+
+	os.Exit(0)
+}
+`, blocks[0].Code())
+	assert.Same(t, body, blocks[1], "not a file block")
+	assert.Contains(t, main.Code(), "<<imports>>", "original unchanged")
+
+	loop := NewCodeBlock(fi, "<<main>>\n", 3, "imports")
+	loop.ResetTitle(nil)
+	_, err = ExpandChunks([]*CodeBlock{main}, []*CodeBlock{main, loop, body})
+	assert.ErrorContains(t, err, "cycle: main -> imports -> main")
+
+	_, err = ExpandChunks([]*CodeBlock{main}, []*CodeBlock{main, body})
+	assert.ErrorContains(t, err, `no block named "imports"`)
+}
+
+func TestTangle(t *testing.T) {
+	fi := NewFile("tangle.md", nil)
+	dir := t.TempDir()
+	written, err := Tangle([]*CodeBlock{
+		newFileBlock(fi, "one\n", 0, "@file=a/b.txt"),
+		NewCodeBlock(fi, "echo not a file\n", 1),
+		newFileBlock(fi, "two\n", 2, "@file=a/b.txt", AppendLabel),
+		newFileBlock(fi, "#!/bin/sh\n", 3, "@file=run.sh @mode=0755"),
+	}, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "a", "b.txt"), filepath.Join(dir, "run.sh")}, written)
+	c, err := os.ReadFile(filepath.Join(dir, "a", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(c))
+	info, err := os.Stat(filepath.Join(dir, "run.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+
+	// Without @append, a block replaces the file.
+	_, err = Tangle([]*CodeBlock{
+		newFileBlock(fi, "three\n", 0, "@file=a/b.txt")}, dir)
+	assert.NoError(t, err)
+	c, err = os.ReadFile(filepath.Join(dir, "a", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "three\n", string(c))
+
+	// Without @mode, a file keeps its mode.
+	assert.NoError(t, os.Chmod(filepath.Join(dir, "a", "b.txt"), 0o600))
+	_, err = Tangle([]*CodeBlock{
+		newFileBlock(fi, "four\n", 0, "@file=a/b.txt", AppendLabel)}, dir)
+	assert.NoError(t, err)
+	info, err = os.Stat(filepath.Join(dir, "a", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	for _, bad := range []string{"@file=../x", "@file=/etc/x"} {
+		_, err = Tangle([]*CodeBlock{newFileBlock(fi, "x\n", 0, bad)}, dir)
+		assert.Error(t, err, bad)
+	}
+}
@@ -3,6 +3,7 @@ package loader
 import (
 	"path/filepath"
 	"strings"
+	"unicode"
 )
 
 // DirBase behavior:
@@ -36,17 +37,109 @@ func CommentBody(s string) string {
 	return s[len(begin) : len(s)-len(end)]
 }
 
+const labelPrefixChar = uint8('@')
+
+// ParseLabels returns the labels, e.g. "@foo", found in the argument.
+// Parameters, e.g. "@timeout=90s", aren't labels; see ParseParams.
 func ParseLabels(s string) (result []Label) {
-	const labelPrefixChar = uint8('@')
-	items := strings.Split(s, " ")
-	for _, word := range items {
+	for _, word := range prefixedWords(s) {
+		if !strings.Contains(word, paramSeparator) {
+			result = append(result, Label(word))
+		}
+	}
+	return
+}
+
+// ParseParams returns the parameters, e.g. "@timeout=90s", found in
+// the argument.  The value of a parameter holding spaces may be
+// enclosed in double quotes, e.g. @waitfor=cmd:"curl -sf localhost".
+// A parameter may appear more than once, e.g. @env=A=1 @env=B=2.
+func ParseParams(s string) (result Params) {
+	for _, word := range prefixedWords(s) {
+		name, value, found := strings.Cut(word, paramSeparator)
+		if found && name != "" {
+			if result == nil {
+				result = make(Params)
+			}
+			result.Add(ParamName(name), value)
+		}
+	}
+	return
+}
+
+// ParseFenceInfo parses the info string of a code fence, e.g.
+//
+//	bash {#install .skip timeout=60s}
+//
+// into a language, labels and parameters.  Inside the braces, #id
+// and .class attributes are labels, and key=value attributes are
+// parameters, as if written @id, @class and @key=value in a comment.
+// Id labels come before class labels, so that an id names the block.
+func ParseFenceInfo(info string) (lang string, labels []Label, params Params) {
+	attrs := ""
+	if i := strings.IndexByte(info, '{'); i >= 0 {
+		if j := strings.LastIndexByte(info, '}'); j > i {
+			attrs = info[i+1 : j]
+			info = info[:i]
+		}
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		lang = fields[0]
+	}
+	var classes []Label
+	for _, word := range splitWords(attrs) {
+		switch {
+		case len(word) < 2:
+		case word[0] == '#':
+			labels = append(labels, Label(word[1:]))
+		case word[0] == '.':
+			classes = append(classes, Label(word[1:]))
+		default:
+			name, value, found := strings.Cut(word, paramSeparator)
+			if found && name != "" {
+				if params == nil {
+					params = make(Params)
+				}
+				params.Add(ParamName(name), value)
+			}
+		}
+	}
+	labels = append(labels, classes...)
+	return
+}
+
+// prefixedWords returns the words in the argument that begin
+// with labelPrefixChar, with the prefix removed.
+func prefixedWords(s string) (result []string) {
+	for _, word := range splitWords(s) {
 		i := 0
 		for i < len(word) && word[i] == labelPrefixChar {
 			i++
 		}
 		if i > 0 && i < len(word) && word[i-1] == labelPrefixChar {
-			result = append(result, Label(word[i:]))
+			result = append(result, word[i:])
 		}
 	}
 	return
 }
+
+// splitWords splits the argument on white space, except for white space
+// inside double quotes.  The double quotes are dropped.
+func splitWords(s string) (result []string) {
+	var (
+		word    strings.Builder
+		inQuote bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			result = append(result, word.String())
+			word.Reset()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	return append(result, word.String())
+}
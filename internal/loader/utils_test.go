@@ -203,6 +203,10 @@ func TestParseLabels(t *testing.T) {
 			data: "  @aa @b  @   @@ccc @@@ @@@d ",
 			want: []Label{"aa", "b", "ccc", "d"},
 		},
+		"paramsAreNotLabels": {
+			data: " @aa @timeout=90s @env=A=1 @b\t@c ",
+			want: []Label{"aa", "b", "c"},
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -212,3 +216,78 @@ func TestParseLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestParseParams(t *testing.T) {
+	tests := map[string]struct {
+		data string
+		want Params
+	}{
+		"none": {
+			data: " @aa @b  = @=x ",
+			want: nil,
+		},
+		"some": {
+			data: " @aa @timeout=90s @env=A=1 @b @env=B=2 @dir=",
+			want: Params{
+				TimeoutParam: {"90s"},
+				EnvParam:     {"A=1", "B=2"},
+				DirParam:     {""},
+			},
+		},
+		"quoted": {
+			data: `@waitfor=cmd:"curl -sf localhost"  @env=X="a b"`,
+			want: Params{
+				"waitfor": {"cmd:curl -sf localhost"},
+				EnvParam:  {"X=a b"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := ParseParams(tc.data)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseFenceInfo(t *testing.T) {
+	tests := map[string]struct {
+		info   string
+		lang   string
+		labels []Label
+		params Params
+	}{
+		"empty": {},
+		"langOnly": {
+			info: "bash",
+			lang: "bash",
+		},
+		"attrs": {
+			info:   `bash {.skip #install timeout=60s env=A="x y"}`,
+			lang:   "bash",
+			labels: []Label{"install", SkipLabel},
+			params: Params{TimeoutParam: {"60s"}, EnvParam: {"A=x y"}},
+		},
+		"noSpace": {
+			info:   "shell{#hello}",
+			lang:   "shell",
+			labels: []Label{"hello"},
+		},
+		"noLang": {
+			info:   "{ #hello  . # }",
+			labels: []Label{"hello"},
+		},
+		"unclosed": {
+			info: "bash {#hello",
+			lang: "bash",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lang, labels, params := ParseFenceInfo(tc.info)
+			assert.Equal(t, tc.lang, lang)
+			assert.Equal(t, tc.labels, labels)
+			assert.Equal(t, tc.params, params)
+		})
+	}
+}
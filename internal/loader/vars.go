@@ -0,0 +1,137 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Vars maps the names of variables to values for substitution into
+// the code of blocks, so that a reader needn't edit placeholders like
+// ${PROJECT_ID} or <your-cluster> by hand.
+type Vars map[string]string
+
+// placeholderRe matches placeholders of the form ${NAME} or <NAME>.
+var placeholderRe = regexp.MustCompile(
+	`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|<([A-Za-z_][A-Za-z0-9_-]*)>`)
+
+// yourPrefix marks placeholders, like <your-cluster>, that must be
+// replaced whether or not a variable is declared for them.
+const yourPrefix = "your-"
+
+// IsVarName is true if the argument can name a variable.
+// Unlike shell names, variable names may hold dashes.
+func IsVarName(s string) bool {
+	return IsShellName(strings.ReplaceAll(s, "-", "_"))
+}
+
+// WithVars returns a copy of the block with placeholders in its code
+// replaced by the values of variables.  The values come from the
+// given vars or, failing that, the front matter of the block's file.
+//
+// A ${NAME} or <NAME> is a placeholder only if NAME is declared,
+// i.e. is in the given vars or the front matter, since code is
+// full of shell variables and angle brackets.  A <your-...> is always
+// a placeholder.  The names of placeholders left unresolved, because
+// their variables have no value, are returned in the order found.
+// If nothing is replaced, the block itself is returned.
+func (cb *CodeBlock) WithVars(vars Vars) (*CodeBlock, []string) {
+	var declared Vars
+	if cb.parent != nil && cb.parent.frontMatter != nil {
+		declared = cb.parent.frontMatter.Vars
+	}
+	var unresolved []string
+	code := placeholderRe.ReplaceAllStringFunc(cb.code, func(m string) string {
+		sub := placeholderRe.FindStringSubmatch(m)
+		name := sub[1] + sub[2]
+		v, inVars := vars[name]
+		dv, inDeclared := declared[name]
+		if v == "" {
+			v = dv
+		}
+		if !inVars && !inDeclared && !strings.HasPrefix(name, yourPrefix) {
+			// Not a placeholder.
+			return m
+		}
+		if v == "" {
+			if !slices.Contains(unresolved, name) {
+				unresolved = append(unresolved, name)
+			}
+			return m
+		}
+		return v
+	})
+	if code == cb.code {
+		return cb, unresolved
+	}
+	result := *cb
+	result.code = code
+	return &result, unresolved
+}
+
+// ExpandVars returns the blocks with placeholders replaced per
+// CodeBlock.WithVars, and an error listing any unresolved placeholders.
+// The error doesn't stop substitution; callers may ignore it.
+func ExpandVars(blocks []*CodeBlock, vars Vars) ([]*CodeBlock, error) {
+	result := make([]*CodeBlock, len(blocks))
+	var problems []string
+	for i, b := range blocks {
+		var unresolved []string
+		result[i], unresolved = b.WithVars(vars)
+		for _, name := range unresolved {
+			problems = append(problems,
+				fmt.Sprintf("%s in block %q at %s", name, b.UniqName(), b.Location()))
+		}
+	}
+	if len(problems) > 0 {
+		return result, fmt.Errorf(
+			"no value for placeholders %s; set them with --set NAME=VALUE",
+			strings.Join(problems, ", "))
+	}
+	return result, nil
+}
+
+// LoadVars returns the variables in the given file, overridden by
+// the given NAME=VALUE assignments.  The file holds one assignment
+// per line; blank lines and lines starting with # are ignored.
+func LoadVars(file string, assignments []string) (Vars, error) {
+	result := make(Vars)
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err = result.set(line); err != nil {
+				return nil, fmt.Errorf("%s:%d; %w", file, n, err)
+			}
+		}
+		if err = sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range assignments {
+		if err := result.set(a); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (v Vars) set(assignment string) error {
+	k, val, found := strings.Cut(assignment, "=")
+	if !found || !IsVarName(k) {
+		return fmt.Errorf("want NAME=VALUE, not %q", assignment)
+	}
+	v[k] = val
+	return nil
+}
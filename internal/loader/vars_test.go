@@ -0,0 +1,72 @@
+package loader_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithVars(t *testing.T) {
+	fi := NewFile("vars.md", nil)
+	fi.SetFrontMatter(&FrontMatter{
+		Vars: Vars{"PROJECT_ID": "", "ZONE": "us-central1-a"}})
+	cb := NewCodeBlock(fi, `gcloud config set project ${PROJECT_ID}
+gcloud config set compute/zone ${ZONE}
+kubectl config use-context <your-cluster>
+echo ${HOME} <div> ${PROJECT_ID}
+`, 0)
+	cb.ResetTitle(nil)
+
+	b, unresolved := cb.WithVars(nil)
+	assert.Equal(t, []string{"PROJECT_ID", "your-cluster"}, unresolved)
+	assert.Contains(t, b.Code(), "compute/zone us-central1-a\n")
+	assert.Contains(t, b.Code(), "project ${PROJECT_ID}\n")
+
+	b, unresolved = cb.WithVars(
+		Vars{"PROJECT_ID": "p1", "your-cluster": "c1", "ZONE": "z1"})
+	assert.Empty(t, unresolved)
+	assert.Equal(t, `gcloud config set project p1
+gcloud config set compute/zone z1
+kubectl config use-context c1
+echo ${HOME} <div> p1
+`, b.Code())
+	assert.Contains(t, cb.Code(), "${PROJECT_ID}", "original unchanged")
+
+	plain := NewCodeBlock(fi, "echo ${HOME}\n", 1)
+	b, unresolved = plain.WithVars(Vars{"X": "y"})
+	assert.Same(t, plain, b)
+	assert.Empty(t, unresolved)
+}
+
+func TestExpandVars(t *testing.T) {
+	fi := NewFile("vars.md", nil)
+	cb := NewCodeBlock(fi, "echo <your-name>\n", 0, "hi")
+	cb.ResetTitle(nil)
+	blocks, err := ExpandVars([]*CodeBlock{cb}, nil)
+	assert.ErrorContains(t, err,
+		`no value for placeholders your-name in block "hi" at vars.md`)
+	assert.Same(t, cb, blocks[0])
+
+	blocks, err = ExpandVars([]*CodeBlock{cb}, Vars{"your-name": "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, "echo bob\n", blocks[0].Code())
+}
+
+func TestLoadVars(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vars")
+	assert.NoError(t, os.WriteFile(file,
+		[]byte("# comment\nA=1\n\nyour-b=two words\n"), 0o600))
+	vars, err := LoadVars(file, []string{"A=3", "C="})
+	assert.NoError(t, err)
+	assert.Equal(t, Vars{"A": "3", "your-b": "two words", "C": ""}, vars)
+
+	_, err = LoadVars("", []string{"1A=3"})
+	assert.ErrorContains(t, err, `want NAME=VALUE, not "1A=3"`)
+
+	assert.NoError(t, os.WriteFile(file, []byte("A=1\nnope\n"), 0o600))
+	_, err = LoadVars(file, nil)
+	assert.ErrorContains(t, err, ":2; want NAME=VALUE")
+}
@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// WaitKind is a kind of condition to wait for.
+type WaitKind string
+
+const (
+	// WaitPort waits for a TCP port to accept connections,
+	// e.g. port:8080 or port:example.com:443.
+	WaitPort = WaitKind(`port`)
+
+	// WaitFile waits for a file to exist, e.g. file:/tmp/ready.
+	WaitFile = WaitKind(`file`)
+
+	// WaitCmd waits for a shell command to succeed,
+	// e.g. cmd:"curl -sf localhost:8080".
+	WaitCmd = WaitKind(`cmd`)
+)
+
+// WaitCondition is something to wait for after a block runs,
+// e.g. for a server started in the background to be ready.
+type WaitCondition struct {
+	Kind WaitKind
+	Arg  string
+}
+
+// String returns the condition in its parameter value form.
+func (w WaitCondition) String() string {
+	return string(w.Kind) + ":" + w.Arg
+}
+
+// ParseWaitCondition parses values like port:8080.
+func ParseWaitCondition(s string) (WaitCondition, error) {
+	k, arg, _ := strings.Cut(s, ":")
+	w := WaitCondition{Kind: WaitKind(k), Arg: arg}
+	if arg == "" {
+		return w, fmt.Errorf("want {kind}:{arg}, not %q", s)
+	}
+	switch w.Kind {
+	case WaitPort:
+		if _, _, err := w.hostPort(); err != nil {
+			return w, err
+		}
+	case WaitFile, WaitCmd:
+	default:
+		return w, fmt.Errorf("unknown kind %q; use %s, %s or %s",
+			k, WaitPort, WaitFile, WaitCmd)
+	}
+	return w, nil
+}
+
+// hostPort returns the host and port of a port condition,
+// with the host defaulting to localhost.
+func (w WaitCondition) hostPort() (host, port string, err error) {
+	host, port = "localhost", w.Arg
+	if i := strings.LastIndex(w.Arg, ":"); i >= 0 {
+		host, port = w.Arg[:i], w.Arg[i+1:]
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("bad port in %q", w.Arg)
+	}
+	return host, port, nil
+}
+
+// Test returns a bash command that succeeds if the condition holds.
+func (w WaitCondition) Test() string {
+	switch w.Kind {
+	case WaitPort:
+		host, port, _ := w.hostPort()
+		return "(: </dev/tcp/" + host + "/" + port + ") 2>/dev/null"
+	case WaitFile:
+		return "[ -e " + ShellQuote(w.Arg) + " ]"
+	default:
+		return "(eval " + ShellQuote(w.Arg) + ") >/dev/null 2>&1"
+	}
+}
+
+// WaitFor returns the conditions to wait for after the block runs.
+func (cb *CodeBlock) WaitFor() ([]WaitCondition, error) {
+	var result []WaitCondition
+	for _, v := range cb.params.Values(WaitForParam) {
+		w, err := ParseWaitCondition(v)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"bad @%s%s%s; %w", WaitForParam, paramSeparator, v, err)
+		}
+		result = append(result, w)
+	}
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package parsren
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+)
+
+// LabelExprUsage describes label expressions in flag help.
+const LabelExprUsage = "a label, or an expression over labels, " +
+	"e.g. 'install && !slow', 'linux || any' or 'k8s-*'"
+
+// ParseLabelExpr parses a boolean expression over block labels into
+// a filter, e.g.
+//
+//	install && !slow
+//	linux || any
+//	k8s-* && (setup || @teardown)
+//
+// A label may have a leading @, and may be a glob as understood by
+// path.Match.  The operators are !, && and ||, in order of decreasing
+// precedence, and parentheses group.  An empty expression passes
+// every block.
+func ParseLabelExpr(expr string) (BlockFilter, error) {
+	p := &labelExprParser{toks: tokenizeLabelExpr(expr)}
+	if len(p.toks) == 0 {
+		return AllBlocks, nil
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("bad label expression %q; %w", expr, err)
+	}
+	if !p.atEnd() {
+		return nil, fmt.Errorf(
+			"bad label expression %q; unexpected %q", expr, p.peek())
+	}
+	return f, nil
+}
+
+const (
+	tokNot   = "!"
+	tokAnd   = "&&"
+	tokOr    = "||"
+	tokOpen  = "("
+	tokClose = ")"
+)
+
+func tokenizeLabelExpr(s string) (toks []string) {
+	for i := 0; i < len(s); {
+		switch {
+		case unicode.IsSpace(rune(s[i])):
+			i++
+		case strings.HasPrefix(s[i:], tokAnd), strings.HasPrefix(s[i:], tokOr):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		case strings.ContainsRune(tokNot+tokOpen+tokClose, rune(s[i])):
+			toks = append(toks, s[i:i+1])
+			i++
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) &&
+				!strings.ContainsRune("!&|()", rune(s[j])) {
+				j++
+			}
+			if j == i {
+				// A lone & or |.
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		}
+	}
+	return
+}
+
+type labelExprParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *labelExprParser) atEnd() bool { return p.pos >= len(p.toks) }
+
+func (p *labelExprParser) peek() string {
+	if p.atEnd() {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+func (p *labelExprParser) parseOr() (BlockFilter, error) {
+	f, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == tokOr {
+		p.pos++
+		g, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		f = Or(f, g)
+	}
+	return f, nil
+}
+
+func (p *labelExprParser) parseAnd() (BlockFilter, error) {
+	f, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == tokAnd {
+		p.pos++
+		g, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		f = And(f, g)
+	}
+	return f, nil
+}
+
+func (p *labelExprParser) parseUnary() (BlockFilter, error) {
+	tok := p.peek()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end")
+	case tokNot:
+		p.pos++
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(f), nil
+	case tokOpen:
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != tokClose {
+			return nil, fmt.Errorf("missing %q", tokClose)
+		}
+		p.pos++
+		return f, nil
+	case tokAnd, tokOr, tokClose, "&", "|":
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+	p.pos++
+	return MatchesLabel(strings.TrimPrefix(tok, "@"))
+}
+
+// MatchesLabel returns a filter passing blocks with a label matching
+// the pattern, which is a glob as understood by path.Match.
+func MatchesLabel(pattern string) (BlockFilter, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad label pattern %q; %w", pattern, err)
+	}
+	return func(b *loader.CodeBlock) bool {
+		for _, l := range b.Labels() {
+			if ok, _ := path.Match(pattern, string(l)); ok {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
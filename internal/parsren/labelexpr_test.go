@@ -0,0 +1,100 @@
+package parsren_test
+
+import (
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	. "github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/stretchr/testify/assert"
+)
+
+func blockWithLabels(labels ...loader.Label) *loader.CodeBlock {
+	b := loader.NewCodeBlock(nil, "echo hi\n", 0)
+	b.AddLabels(labels)
+	return b
+}
+
+func TestParseLabelExpr(t *testing.T) {
+	var (
+		install     = blockWithLabels("install")
+		installSlow = blockWithLabels("install", "slow")
+		linux       = blockWithLabels("linux")
+		k8sApply    = blockWithLabels("k8s-apply")
+		none        = blockWithLabels()
+	)
+	for name, tc := range map[string]struct {
+		expr string
+		pass []*loader.CodeBlock
+		fail []*loader.CodeBlock
+	}{
+		"empty": {
+			expr: "  ",
+			pass: []*loader.CodeBlock{install, none},
+		},
+		"one": {
+			expr: "install",
+			pass: []*loader.CodeBlock{install, installSlow},
+			fail: []*loader.CodeBlock{linux, none},
+		},
+		"at": {
+			expr: "@install",
+			pass: []*loader.CodeBlock{install},
+			fail: []*loader.CodeBlock{linux},
+		},
+		"andNot": {
+			expr: "install && !slow",
+			pass: []*loader.CodeBlock{install},
+			fail: []*loader.CodeBlock{installSlow, linux},
+		},
+		"or": {
+			expr: "linux||install",
+			pass: []*loader.CodeBlock{install, linux},
+			fail: []*loader.CodeBlock{k8sApply, none},
+		},
+		"glob": {
+			expr: "k8s-*",
+			pass: []*loader.CodeBlock{k8sApply},
+			fail: []*loader.CodeBlock{install},
+		},
+		"precedence": {
+			expr: "linux || install && slow",
+			pass: []*loader.CodeBlock{linux, installSlow},
+			fail: []*loader.CodeBlock{install},
+		},
+		"parens": {
+			expr: "!(linux || install)",
+			pass: []*loader.CodeBlock{k8sApply, none},
+			fail: []*loader.CodeBlock{linux, installSlow},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := ParseLabelExpr(tc.expr)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, b := range tc.pass {
+				assert.True(t, f(b), "%v", b.Labels())
+			}
+			for _, b := range tc.fail {
+				assert.False(t, f(b), "%v", b.Labels())
+			}
+		})
+	}
+}
+
+func TestParseLabelExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		"install &&",
+		"&& install",
+		"(install",
+		"install)",
+		"install slow",
+		"install & slow",
+		"[bad",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseLabelExpr(expr)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package parsren
 
 import (
-	"github.com/monopole/mdrip/v2/internal/loader"
+	"fmt"
 	"html/template"
+	"slices"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
 )
 
 // BlockFilter is a function that returns true or false based on the
@@ -15,6 +19,308 @@ var AllBlocksButSkip = func(b *loader.CodeBlock) bool {
 	return !b.HasLabel(loader.SkipLabel)
 }
 
+// NotExpectedOutput passes blocks that don't hold the expected output
+// of some other block, i.e. blocks that might be run.
+var NotExpectedOutput = func(b *loader.CodeBlock) bool {
+	return !b.IsExpectedOutput()
+}
+
+// HasLabel returns a filter passing blocks that have the given label.
+func HasLabel(l loader.Label) BlockFilter {
+	return func(b *loader.CodeBlock) bool {
+		return b.HasLabel(l)
+	}
+}
+
+// InLangs returns a filter passing blocks whose fence language is one of
+// the given languages.  If no languages are given, every block passes.
+func InLangs(langs []string) BlockFilter {
+	if len(langs) == 0 {
+		return AllBlocks
+	}
+	return func(b *loader.CodeBlock) bool {
+		return b.HasLang(langs...)
+	}
+}
+
+// And returns a filter passing blocks that pass all the given filters.
+func And(filters ...BlockFilter) BlockFilter {
+	return func(b *loader.CodeBlock) bool {
+		for _, f := range filters {
+			if !f(b) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns a filter passing blocks that pass any of the given filters.
+func Or(filters ...BlockFilter) BlockFilter {
+	return func(b *loader.CodeBlock) bool {
+		for _, f := range filters {
+			if f(b) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a filter passing blocks that fail the given filter.
+func Not(f BlockFilter) BlockFilter {
+	return func(b *loader.CodeBlock) bool {
+		return !f(b)
+	}
+}
+
+// FilterWithLifecycle returns the blocks that pass both filters,
+// plus, for each file holding such a block, that file's setup and
+// teardown blocks that pass the base filter.  Within a file, setup
+// blocks come first and teardown blocks last.
+//
+// Blocks come after the blocks they need, per their NeedsParam.
+// Within a file, a needed block is moved ahead of the blocks that
+// need it.  A file's blocks stay together, so a file holding needed
+// blocks is moved ahead of the files needing them.  It's an error if
+// files need each other in a cycle.
+func FilterWithLifecycle(
+	p MdParserRenderer,
+	selector, base BlockFilter) ([]*loader.CodeBlock, error) {
+	all := p.Filter(AllBlocks)
+	var groups [][]*loader.CodeBlock
+	for _, f := range p.RenderedMdFiles() {
+		var setup, main, teardown []*loader.CodeBlock
+		selected := false
+		for _, b := range f.Blocks {
+			if !base(b) {
+				continue
+			}
+			isSelected := selector(b)
+			selected = selected || isSelected
+			switch {
+			case b.IsSetup():
+				setup = append(setup, b)
+			case b.IsTeardown():
+				teardown = append(teardown, b)
+			case isSelected:
+				main = append(main, b)
+			}
+		}
+		if selected {
+			group := append(setup, orderByNeeds(all, main)...)
+			groups = append(groups, append(group, teardown...))
+		}
+	}
+	groups, err := orderFilesByNeeds(all, groups)
+	if err != nil {
+		return nil, err
+	}
+	var result []*loader.CodeBlock
+	for _, g := range groups {
+		result = append(result, g...)
+	}
+	return result, nil
+}
+
+// neededIn returns the blocks in the set that the block needs.
+func neededIn(
+	all []*loader.CodeBlock, b *loader.CodeBlock,
+	set map[*loader.CodeBlock]bool) (result []*loader.CodeBlock) {
+	for _, ref := range b.Needs() {
+		// SelectWithNeeds reports bad references.
+		if needed, err := findNeeded(all, b, ref); err == nil && set[needed] {
+			result = append(result, needed)
+		}
+	}
+	return
+}
+
+// orderByNeeds returns the blocks, all from one file, with each
+// block after the blocks it needs, and otherwise in document order.
+// SelectWithNeeds has ruled out cycles.
+func orderByNeeds(all, blocks []*loader.CodeBlock) []*loader.CodeBlock {
+	set := make(map[*loader.CodeBlock]bool, len(blocks))
+	for _, b := range blocks {
+		set[b] = true
+	}
+	var (
+		result []*loader.CodeBlock
+		visit  func(b *loader.CodeBlock)
+	)
+	seen := make(map[*loader.CodeBlock]bool, len(blocks))
+	visit = func(b *loader.CodeBlock) {
+		if seen[b] {
+			return
+		}
+		seen[b] = true
+		for _, needed := range neededIn(all, b, set) {
+			visit(needed)
+		}
+		result = append(result, b)
+	}
+	for _, b := range blocks {
+		visit(b)
+	}
+	return result
+}
+
+// orderFilesByNeeds returns the groups of blocks, one group per file,
+// with each group after the groups holding blocks that it needs, and
+// otherwise in the given order.
+func orderFilesByNeeds(
+	all []*loader.CodeBlock,
+	groups [][]*loader.CodeBlock) ([][]*loader.CodeBlock, error) {
+	groupOf := make(map[*loader.CodeBlock]int)
+	set := make(map[*loader.CodeBlock]bool)
+	for i, g := range groups {
+		for _, b := range g {
+			groupOf[b] = i
+			set[b] = true
+		}
+	}
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make([]int, len(groups))
+	var (
+		result [][]*loader.CodeBlock
+		path   []int
+		visit  func(i int) error
+	)
+	visit = func(i int) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			var files []string
+			for _, j := range append(path[slices.Index(path, i):], i) {
+				files = append(files, string(groups[j][0].Path()))
+			}
+			return fmt.Errorf("files need each other's blocks in a cycle: %s",
+				strings.Join(files, " -> "))
+		}
+		state[i] = visiting
+		path = append(path, i)
+		for _, b := range groups[i] {
+			for _, needed := range neededIn(all, b, set) {
+				if j := groupOf[needed]; j != i {
+					if err := visit(j); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = done
+		result = append(result, groups[i])
+		return nil
+	}
+	for i := range groups {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// SelectWithNeeds returns a filter passing the blocks that pass the
+// selector, plus the blocks they need, transitively, per their
+// NeedsParam.  It's an error if a needed block can't be found, or
+// if blocks need each other in a cycle.
+func SelectWithNeeds(
+	p MdParserRenderer, selector BlockFilter) (BlockFilter, error) {
+	all := p.Filter(AllBlocks)
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[*loader.CodeBlock]int)
+	var (
+		path  []*loader.CodeBlock
+		visit func(b *loader.CodeBlock) error
+	)
+	visit = func(b *loader.CodeBlock) error {
+		switch state[b] {
+		case done:
+			return nil
+		case visiting:
+			// Report only the blocks in the cycle.
+			var names []string
+			for _, c := range append(path[slices.Index(path, b):], b) {
+				names = append(names, c.UniqName())
+			}
+			return fmt.Errorf("blocks need each other in a cycle: %s",
+				strings.Join(names, " -> "))
+		}
+		state[b] = visiting
+		path = append(path, b)
+		for _, ref := range b.Needs() {
+			needed, err := findNeeded(all, b, ref)
+			if err != nil {
+				return err
+			}
+			if err = visit(needed); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[b] = done
+		return nil
+	}
+	for _, b := range all {
+		if selector(b) {
+			if err := visit(b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return func(b *loader.CodeBlock) bool {
+		return state[b] == done
+	}, nil
+}
+
+// findNeeded returns the block named by the reference, which is a
+// block name, optionally preceded by a file path and #.  Without a
+// path, the block must be in the same file as the needing block.
+// A path matches a file if it's the file's path or a suffix of it.
+func findNeeded(
+	all []*loader.CodeBlock,
+	from *loader.CodeBlock, ref string) (*loader.CodeBlock, error) {
+	file, name, found := strings.Cut(ref, "#")
+	if !found {
+		file, name = "", ref
+	}
+	var result *loader.CodeBlock
+	for _, b := range all {
+		if b.UniqName() != name || !pathMatches(b, from, file) {
+			continue
+		}
+		if result != nil && result.Path() != b.Path() {
+			return nil, fmt.Errorf(
+				"block %q at %s needs %q, which is in both %s and %s",
+				from.UniqName(), from.Location(), ref, result.Path(), b.Path())
+		}
+		if result == nil {
+			result = b
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("block %q at %s needs %q, which doesn't exist",
+			from.UniqName(), from.Location(), ref)
+	}
+	return result, nil
+}
+
+func pathMatches(b, from *loader.CodeBlock, file string) bool {
+	if file == "" {
+		return b.Path() == from.Path()
+	}
+	p := string(b.Path())
+	return p == file || strings.HasSuffix(p, "/"+file)
+}
+
 // MdParserRenderer is a tree visitor that parses and renders markdown.
 // The two operations are closely coupled by a shared abstract syntax tree
 // and shared raw bytes from the source markdown.
@@ -41,6 +347,8 @@ type RenderedMdFile struct {
 	Index int
 	// Path is the path to the file.
 	Path loader.FilePath
+	// FrontMatter holds the file's front matter, or nil if it has none.
+	FrontMatter *loader.FrontMatter
 	// Html is the ready-to-rock HTML rendered from the file's markdown.
 	Html template.HTML
 	// Blocks holds all the code blocks found in the file.
@@ -128,14 +128,23 @@ func (v *GParser) VisitFolder(fl *loader.MyFolder) {
 func (v *GParser) VisitFile(fi *loader.MyFile) {
 	v.currentFile = fi
 
+	fm, size, err := fi.ParseFrontMatter()
+	if err != nil {
+		if v.err == nil {
+			v.err = fmt.Errorf("%s; %w", fi.Path(), err)
+		}
+		return
+	}
+
 	// fileRootNode is the root of an abstract syntax tree discovered by
 	// parsing the file content.
 	// fileRootNode cannot be used alone; it holds pointers into the
 	// file's byte array, rather than actually holding a copy
 	// of the bytes.
-	fileRootNode := v.p.Parser().Parse(text.NewReader(fi.C()))
+	fileRootNode := v.p.Parser().Parse(
+		text.NewReader(blankOutFrontMatter(fi.C(), size)))
 
-	fencedBlocks, err := gatherFencedCodeBlocks(fileRootNode)
+	fencedBlocks, headings, err := gatherFencedCodeBlocks(fileRootNode, fi.C())
 	if err != nil {
 		if v.err == nil {
 			v.err = err
@@ -150,6 +159,8 @@ func (v *GParser) VisitFile(fi *loader.MyFile) {
 		hBlocks[i] = v.swapOutFcbForHcb(fencedBlocks[i])
 	}
 
+	scopes := v.gatherScopes(fileRootNode, fm)
+
 	// To assure no two titles in the same file match.
 	titleDisambiguate := make(map[string]int)
 
@@ -159,7 +170,13 @@ func (v *GParser) VisitFile(fi *loader.MyFile) {
 	//   e.g. rendering in a left nav.
 	for i, hcb := range hBlocks {
 		lCb := v.convertHighlightedToLoaderCodeBlock(hcb, i)
+		lCb.SetHeadings(headings[i])
 		lCb.ResetTitle(titleDisambiguate)
+		// Inherit labels only after the title is set, so that
+		// a section's label doesn't become the name of its blocks.
+		for _, sc := range scopes[topLevelNode(hcb)] {
+			sc.applyTo(lCb)
+		}
 		inventory = append(inventory, lCb)
 		// Store zero-relative indices as node attributes
 		// in the syntax tree for later use in rendering
@@ -169,27 +186,101 @@ func (v *GParser) VisitFile(fi *loader.MyFile) {
 		hcb.Title = lCb.Title()
 		// hcb.dump(v.currentFile.C(), 0)
 	}
+	if err := pairExpectedOutput(hBlocks, inventory); err != nil {
+		if v.err == nil {
+			v.err = err
+		}
+		return
+	}
 
 	rf := &parsren.RenderedMdFile{
 		Index: len(v.renderMdFiles),
 		// One cannot render the file until _after_ the above loop that
 		// sets attributes on the fenced code blocks.
-		Html:   v.renderMdFile(fi, fileRootNode),
-		Path:   fi.Path(),
-		Blocks: inventory,
+		Html:        v.renderMdFile(fi, fileRootNode),
+		Path:        fi.Path(),
+		FrontMatter: fm,
+		Blocks:      inventory,
 	}
 	v.renderMdFiles = append(v.renderMdFiles, rf)
 }
 
-func gatherFencedCodeBlocks(n ast.Node) (
-	result []*ast.FencedCodeBlock, err error) {
+// blankOutFrontMatter returns the content with its first size bytes,
+// holding front matter, replaced by blank lines, so that the front
+// matter isn't rendered, while offsets into the content (and so line
+// numbers) are unchanged.
+func blankOutFrontMatter(c []byte, size int) []byte {
+	if size == 0 {
+		return c
+	}
+	result := bytes.Clone(c)
+	for i := 0; i < size; i++ {
+		if result[i] != '\n' {
+			result[i] = ' '
+		}
+	}
+	return result
+}
+
+// outputLangs are fence languages that mark a block as holding the
+// expected output of the block immediately preceding it.
+var outputLangs = []string{"output", "text"}
+
+// pairExpectedOutput finds blocks holding the expected output of other
+// blocks.  Such a block either has the ExpectLabel (or ExpectParam), or
+// is fenced with one of the outputLangs and immediately follows its code
+// block with nothing in between.  It's an error if a block labelled
+// as expected output has no code block before it, lest it be run.
+func pairExpectedOutput(
+	hBlocks []*codeblock.HighlightedCodeBlock,
+	blocks []*loader.CodeBlock) error {
+	for i, b := range blocks {
+		isLabelled := b.HasLabel(loader.ExpectLabel) || b.HasParam(loader.ExpectParam)
+		if i == 0 || blocks[i-1].IsExpectedOutput() {
+			if isLabelled {
+				return fmt.Errorf(
+					"block at %s holds expected output, but follows no code block",
+					b.Location())
+			}
+			continue
+		}
+		if isLabelled ||
+			(b.HasLang(outputLangs...) &&
+				hBlocks[i].PreviousSibling() == hBlocks[i-1]) {
+			blocks[i-1].SetExpectedOutput(b)
+		}
+	}
+	return nil
+}
+
+// gatherFencedCodeBlocks returns the fenced code blocks below n,
+// and, for each block, the text of the headings enclosing it,
+// outermost first.
+func gatherFencedCodeBlocks(n ast.Node, src []byte) (
+	result []*ast.FencedCodeBlock, headings [][]string, err error) {
+	// open holds the headings enclosing the current node,
+	// outermost first.
+	var open []*ast.Heading
 	err = ast.Walk(
 		n,
 		func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 			if !entering {
 				return ast.WalkContinue, nil
 			}
-			if n.Kind() == ast.KindFencedCodeBlock {
+			switch n.Kind() {
+			case ast.KindHeading:
+				h, ok := n.(*ast.Heading)
+				if !ok {
+					return ast.WalkStop, fmt.Errorf(
+						"ast.Kind() appears to be confused")
+				}
+				for len(open) > 0 && open[len(open)-1].Level >= h.Level {
+					open = open[:len(open)-1]
+				}
+				open = append(open, h)
+				// No code blocks inside a heading.
+				return ast.WalkSkipChildren, nil
+			case ast.KindFencedCodeBlock:
 				fcb, ok := n.(*ast.FencedCodeBlock)
 				if !ok {
 					return ast.WalkStop, fmt.Errorf(
@@ -197,6 +288,7 @@ func gatherFencedCodeBlocks(n ast.Node) (
 				}
 				if !parentIsBlockQuote(n) {
 					result = append(result, fcb)
+					headings = append(headings, headingTexts(open, src))
 				}
 			}
 			return ast.WalkContinue, nil
@@ -204,6 +296,22 @@ func gatherFencedCodeBlocks(n ast.Node) (
 	return
 }
 
+// headingTexts returns the text of each heading.
+func headingTexts(hs []*ast.Heading, src []byte) (result []string) {
+	for _, h := range hs {
+		var buff strings.Builder
+		for i := 0; i < h.Lines().Len(); i++ {
+			s := h.Lines().At(i)
+			if buff.Len() > 0 {
+				buff.WriteByte(' ')
+			}
+			buff.Write(bytes.TrimSpace(s.Value(src)))
+		}
+		result = append(result, buff.String())
+	}
+	return
+}
+
 // swapOutFcbForHcb rejiggers the AST, inserting a new parent for a
 // FencedCodeBlock.
 func (v *GParser) swapOutFcbForHcb(
@@ -235,7 +343,21 @@ func (v *GParser) convertHighlightedToLoaderCodeBlock(
 	hCb *codeblock.HighlightedCodeBlock, index int) *loader.CodeBlock {
 	lCb := loader.NewCodeBlock(
 		v.currentFile, v.nodeText(hCb.FirstChild()), index)
+	if lines := hCb.FirstChild().Lines(); lines.Len() > 0 {
+		line, column := v.position(lines.At(0).Start)
+		endLine, _ := v.position(lines.At(lines.Len()-1).Stop - 1)
+		lCb.SetPosition(line, column, endLine)
+	}
 	v.maybeAddLabels(lCb, hCb.PreviousSibling())
+	if fcb, ok := hCb.FirstChild().(*ast.FencedCodeBlock); ok && fcb.Info != nil {
+		// Attributes in the fence itself, e.g. {#install .skip},
+		// follow any labels from a preceding comment.
+		lang, labels, params := loader.ParseFenceInfo(
+			string(fcb.Info.Segment.Value(v.currentFile.C())))
+		lCb.SetLang(lang)
+		lCb.AddLabels(labels)
+		lCb.AddParams(params)
+	}
 	return lCb
 }
 
@@ -243,15 +365,113 @@ func (v *GParser) maybeAddLabels(cb *loader.CodeBlock, prev ast.Node) {
 	if prev != nil && prev.Kind() == ast.KindHTMLBlock {
 		if htmlBlock, ok := prev.(*ast.HTMLBlock); ok {
 			// We have a preceding HTML block.
-			// If it's an HTML comment, try to extract labels.
+			// If it's an HTML comment, try to extract labels and params.
 			// If no labels found, the label array remains empty,
 			// i.e. no label defaults are actually stored here.
-			cb.AddLabels(
-				loader.ParseLabels(loader.CommentBody(v.nodeText(htmlBlock))))
+			body := loader.CommentBody(v.nodeText(htmlBlock))
+			cb.AddLabels(loader.ParseLabels(body))
+			cb.AddParams(loader.ParseParams(body))
 		}
 	}
 }
 
+// scope holds the labels and params of a heading, or of the file,
+// which apply to every code block in the heading's section
+// (including subsections), or in the file.
+type scope struct {
+	// level is the heading level, or zero for the file.
+	level  int
+	labels []loader.Label
+	params loader.Params
+}
+
+// applyTo adds the scope's labels and params to the block.
+// A param the block already has isn't changed, so a block
+// overrides its section, and a section overrides the file.
+func (sc *scope) applyTo(cb *loader.CodeBlock) {
+	for _, l := range sc.labels {
+		if !cb.HasLabel(l) {
+			cb.AddLabels([]loader.Label{l})
+		}
+	}
+	for n, values := range sc.params {
+		if !cb.HasParam(n) {
+			cb.AddParams(loader.Params{n: values})
+		}
+	}
+}
+
+// gatherScopes maps each top level node of the file to the scopes
+// enclosing it, innermost first.
+//
+// A heading's labels come from an HTML comment right after the heading.
+// The file's labels come from the front matter, if any, and from HTML
+// comments above the first heading.
+// A comment immediately followed by a code block belongs to that
+// block instead, so it's never a scope comment.
+func (v *GParser) gatherScopes(
+	root ast.Node, fm *loader.FrontMatter) map[ast.Node][]*scope {
+	result := make(map[ast.Node][]*scope)
+	file := &scope{labels: fm.BlockLabels(), params: make(loader.Params)}
+	for name, values := range fm.BlockParams() {
+		file.params[name] = values
+	}
+	// stack holds the open scopes, outermost (the file) first.
+	stack := []*scope{file}
+	for n := root.FirstChild(); n != nil; n = n.NextSibling() {
+		if h, ok := n.(*ast.Heading); ok {
+			for len(stack) > 1 && stack[len(stack)-1].level >= h.Level {
+				stack = stack[:len(stack)-1]
+			}
+			sc := &scope{level: h.Level, params: make(loader.Params)}
+			v.maybeAddScopeLabels(sc, h.NextSibling())
+			stack = append(stack, sc)
+		} else if len(stack) == 1 {
+			v.maybeAddScopeLabels(stack[0], n)
+		}
+		enclosing := make([]*scope, len(stack))
+		for i := range stack {
+			enclosing[len(stack)-1-i] = stack[i]
+		}
+		result[n] = enclosing
+	}
+	return result
+}
+
+// maybeAddScopeLabels adds labels and params to the scope if
+// the node is an HTML comment that doesn't precede a code block.
+func (v *GParser) maybeAddScopeLabels(sc *scope, n ast.Node) {
+	htmlBlock, ok := n.(*ast.HTMLBlock)
+	if !ok {
+		return
+	}
+	if _, ok = n.NextSibling().(*codeblock.HighlightedCodeBlock); ok {
+		return
+	}
+	body := loader.CommentBody(v.nodeText(htmlBlock))
+	sc.labels = append(sc.labels, loader.ParseLabels(body)...)
+	for name, values := range loader.ParseParams(body) {
+		sc.params[name] = append(sc.params[name], values...)
+	}
+}
+
+// topLevelNode returns the ancestor of n that is a child
+// of the document, or n itself if n is such a child.
+func topLevelNode(n ast.Node) ast.Node {
+	for n.Parent() != nil && n.Parent().Kind() != ast.KindDocument {
+		n = n.Parent()
+	}
+	return n
+}
+
+// position returns the one-relative line and column
+// of the given byte offset in the current file.
+func (v *GParser) position(offset int) (line, column int) {
+	before := v.currentFile.C()[:offset]
+	return bytes.Count(before, []byte("\n")) + 1,
+		offset - bytes.LastIndexByte(before, '\n')
+}
+
 // TODO: Could change this to preserve lines?
 func (v *GParser) nodeText(n ast.Node) string {
 	var buff strings.Builder
@@ -90,7 +90,7 @@ func TestRenderingHtmlFromStringConstants(t *testing.T) {
 <p>Some text before a code block.</p>
 <div class='codeBlockContainer' id='codeBlockId0'>
 <div class='codeBlockControl'>
-<span class='codeBlockTitle'> echoAlphaWhichFind </span>
+<span class='codeBlockTitle'> header </span>
 </div>
 <div class='codeBlockPrompt'> ` + prompt + ` </div>
 <div class='codeBlockArea'><pre><code>echo alpha
@@ -134,7 +134,7 @@ which ls
 </div></div><p>The next block has no labels.</p>
 <div class='codeBlockContainer' id='codeBlockId2'>
 <div class='codeBlockControl'>
-<span class='codeBlockTitle'> echoGammaWhichCat </span>
+<span class='codeBlockTitle'> header </span>
 </div>
 <div class='codeBlockPrompt'> ` + prompt + ` </div>
 <div class='codeBlockArea'><pre><code>echo gamma
@@ -247,3 +247,613 @@ func TestParsingTree(t *testing.T) {
 		fmt.Println("</body></html>")
 	}
 }
+
+func TestParsingLanguages(t *testing.T) {
+	const content = `
+# header
+` + "```bash" + `
+echo alpha
+` + "```" + `
+` + "```yaml" + `
+kind: Pod
+` + "```" + `
+` + "```" + `
+echo gamma
+` + "```" + `
+` + "```Shell" + `
+echo delta
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("langs", []byte(content)).Accept(p)
+	if !assert.Equal(t, 1, len(p.RenderedMdFiles())) {
+		t.FailNow()
+	}
+	blocks := p.RenderedMdFiles()[0].Blocks
+	if !assert.Equal(t, 4, len(blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "bash", blocks[0].Lang())
+	assert.Equal(t, "yaml", blocks[1].Lang())
+	assert.Equal(t, "", blocks[2].Lang())
+	assert.Equal(t, "Shell", blocks[3].Lang())
+
+	blocks = p.Filter(parsren.InLangs([]string{"shell", "bash"}))
+	if !assert.Equal(t, 2, len(blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "echo alpha\n", blocks[0].Code())
+	assert.Equal(t, "echo delta\n", blocks[1].Code())
+
+	assert.Equal(t, 4, len(p.Filter(parsren.InLangs(nil))))
+}
+
+func TestPairingExpectedOutput(t *testing.T) {
+	const content = `
+# header
+` + "```bash" + `
+echo alpha
+` + "```" + `
+` + "```output" + `
+alpha
+` + "```" + `
+
+Some text.
+
+` + "```bash" + `
+echo beta
+` + "```" + `
+The output:
+<!-- @expect -->
+` + "```" + `
+beta
+` + "```" + `
+
+` + "```bash" + `
+echo gamma
+` + "```" + `
+
+Not adjacent, so not output.
+
+` + "```text" + `
+gamma
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("expect", []byte(content)).Accept(p)
+	if !assert.Equal(t, 1, len(p.RenderedMdFiles())) {
+		t.FailNow()
+	}
+	blocks := p.RenderedMdFiles()[0].Blocks
+	if !assert.Equal(t, 6, len(blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, blocks[1], blocks[0].ExpectedOutput())
+	assert.True(t, blocks[1].IsExpectedOutput())
+	assert.Equal(t, blocks[3], blocks[2].ExpectedOutput())
+	assert.True(t, blocks[3].IsExpectedOutput())
+	assert.Nil(t, blocks[4].ExpectedOutput())
+	assert.False(t, blocks[5].IsExpectedOutput())
+	assert.Equal(t, 4, len(p.Filter(parsren.NotExpectedOutput)))
+}
+
+func TestPairingExpectedOutputWithNothingToPair(t *testing.T) {
+	for name, content := range map[string]string{
+		"first": `
+<!-- @expect -->
+` + "```" + `
+alpha
+` + "```" + `
+`,
+		"afterOutput": `
+` + "```bash" + `
+echo alpha
+` + "```" + `
+` + "```output" + `
+alpha
+` + "```" + `
+<!-- @expect -->
+` + "```" + `
+alpha
+` + "```" + `
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := NewGParser()
+			loader.NewFile("expect", []byte(content)).Accept(p)
+			if assert.Error(t, p.Error()) {
+				assert.Contains(t, p.Error().Error(), "follows no code block")
+			}
+			assert.Empty(t, p.RenderedMdFiles())
+		})
+	}
+}
+
+func TestFilterWithLifecycle(t *testing.T) {
+	const content = `
+<!-- @cleanup -->
+` + "```" + `
+echo gone
+` + "```" + `
+<!-- @foo -->
+` + "```" + `
+echo foo
+` + "```" + `
+<!-- @setup -->
+` + "```" + `
+echo setup
+` + "```" + `
+<!-- @bar -->
+` + "```" + `
+echo bar
+` + "```" + `
+`
+	const other = `
+<!-- @setup -->
+` + "```" + `
+echo other setup
+` + "```" + `
+<!-- @bar -->
+` + "```" + `
+echo other bar
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("life", []byte(content)).Accept(p)
+	loader.NewFile("other", []byte(other)).Accept(p)
+
+	codes := func(blocks []*loader.CodeBlock, err error) (result []string) {
+		assert.NoError(t, err)
+		for _, b := range blocks {
+			result = append(result, b.Code())
+		}
+		return
+	}
+	assert.Equal(t,
+		[]string{"echo setup\n", "echo foo\n", "echo gone\n"},
+		codes(parsren.FilterWithLifecycle(
+			p, parsren.HasLabel("foo"), parsren.AllBlocks)))
+	assert.Equal(t,
+		[]string{
+			"echo setup\n", "echo foo\n", "echo bar\n", "echo gone\n",
+			"echo other setup\n", "echo other bar\n"},
+		codes(parsren.FilterWithLifecycle(
+			p, parsren.AllBlocks, parsren.AllBlocks)))
+	assert.Equal(t,
+		[]string{"echo setup\n", "echo bar\n"},
+		codes(parsren.FilterWithLifecycle(
+			p, parsren.HasLabel("bar"), func(b *loader.CodeBlock) bool {
+				return !b.IsTeardown() && b.Path() == "life"
+			})))
+}
+
+func TestSelectWithNeeds(t *testing.T) {
+	const install = `
+<!-- @install -->
+` + "```" + `
+echo install
+` + "```" + `
+<!-- @unrelated -->
+` + "```" + `
+echo unrelated
+` + "```" + `
+`
+	const content = `
+<!-- @config @needs=dir/install.md#install -->
+` + "```" + `
+echo config
+` + "```" + `
+<!-- @other -->
+` + "```" + `
+echo other
+` + "```" + `
+<!-- @run @needs=config -->
+` + "```" + `
+echo run
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("dir/install.md", []byte(install)).Accept(p)
+	loader.NewFile("tut.md", []byte(content)).Accept(p)
+
+	f, err := parsren.SelectWithNeeds(p, parsren.HasLabel("run"))
+	assert.NoError(t, err)
+	var names []string
+	for _, b := range p.Filter(f) {
+		names = append(names, b.UniqName())
+	}
+	assert.Equal(t, []string{"install", "config", "run"}, names)
+
+	f, err = parsren.SelectWithNeeds(p, parsren.HasLabel("other"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(p.Filter(f)))
+}
+
+func TestFilterWithLifecycleOrdersByNeeds(t *testing.T) {
+	// The needing file comes first in document order.
+	const a = `
+<!-- @aSetup @setup -->
+` + "```" + `
+echo a setup
+` + "```" + `
+<!-- @use @needs=b.md#install -->
+` + "```" + `
+echo use
+` + "```" + `
+<!-- @check -->
+` + "```" + `
+echo check
+` + "```" + `
+<!-- @prepare -->
+` + "```" + `
+echo prepare
+` + "```" + `
+<!-- @final @needs=prepare -->
+` + "```" + `
+echo final
+` + "```" + `
+`
+	const b = `
+<!-- @install -->
+` + "```" + `
+echo install
+` + "```" + `
+<!-- @bTeardown @teardown -->
+` + "```" + `
+echo b teardown
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("a.md", []byte(a)).Accept(p)
+	loader.NewFile("b.md", []byte(b)).Accept(p)
+
+	names := func(selector parsren.BlockFilter) (result []string) {
+		f, err := parsren.SelectWithNeeds(p, selector)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		blocks, err := parsren.FilterWithLifecycle(p, f, parsren.AllBlocks)
+		assert.NoError(t, err)
+		for _, b := range blocks {
+			result = append(result, b.UniqName())
+		}
+		return
+	}
+	// b.md, with its lifecycle, runs ahead of the block needing it.
+	assert.Equal(t,
+		[]string{"install", "bTeardown", "aSetup", "use"},
+		names(parsren.HasLabel("use")))
+	assert.Equal(t,
+		[]string{"install", "bTeardown", "aSetup", "use", "check", "prepare", "final"},
+		names(parsren.AllBlocks))
+	// Within a file, a needed block moves ahead of its needer.
+	assert.Equal(t,
+		[]string{"aSetup", "prepare", "final"},
+		names(parsren.HasLabel("final")))
+}
+
+func TestFilterWithLifecycleFileCycle(t *testing.T) {
+	const a = `
+<!-- @a1 -->
+` + "```" + `
+echo a1
+` + "```" + `
+<!-- @a2 @needs=b.md#b1 -->
+` + "```" + `
+echo a2
+` + "```" + `
+`
+	const b = `
+<!-- @b1 @needs=a.md#a1 -->
+` + "```" + `
+echo b1
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("a.md", []byte(a)).Accept(p)
+	loader.NewFile("b.md", []byte(b)).Accept(p)
+	f, err := parsren.SelectWithNeeds(p, parsren.HasLabel("a2"))
+	assert.NoError(t, err)
+	_, err = parsren.FilterWithLifecycle(p, f, parsren.AllBlocks)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cycle: a.md -> b.md -> a.md")
+	}
+}
+
+func TestSelectWithNeedsErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		errMsg  string
+	}{
+		"missing": {
+			content: `
+<!-- @a @needs=nope -->
+` + "```" + `
+echo a
+` + "```" + `
+`,
+			errMsg: `needs "nope", which doesn't exist`,
+		},
+		"cycle": {
+			content: `
+<!-- @a @needs=c -->
+` + "```" + `
+echo a
+` + "```" + `
+<!-- @b @needs=a -->
+` + "```" + `
+echo b
+` + "```" + `
+<!-- @c @needs=b -->
+` + "```" + `
+echo c
+` + "```" + `
+`,
+			errMsg: "cycle: a -> c -> b -> a",
+		},
+		"cycleBelowRoot": {
+			content: `
+<!-- @a @needs=b -->
+` + "```" + `
+echo a
+` + "```" + `
+<!-- @b @needs=c -->
+` + "```" + `
+echo b
+` + "```" + `
+<!-- @c @needs=b -->
+` + "```" + `
+echo c
+` + "```" + `
+`,
+			errMsg: "blocks need each other in a cycle: b -> c -> b",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := NewGParser()
+			loader.NewFile("f.md", []byte(tc.content)).Accept(p)
+			_, err := parsren.SelectWithNeeds(p, parsren.HasLabel("a"))
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestParsingFenceAttributes(t *testing.T) {
+	const content = `
+` + "```bash {#install .skip timeout=60s}" + `
+echo install
+` + "```" + `
+<!-- @first -->
+` + "```shell {#second retry=2}" + `
+echo hello
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("attrs", []byte(content)).Accept(p)
+	blocks := p.RenderedMdFiles()[0].Blocks
+	if !assert.Equal(t, 2, len(blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "bash", blocks[0].Lang())
+	assert.Equal(t, "install", blocks[0].UniqName())
+	assert.True(t, blocks[0].HasLabel(loader.SkipLabel))
+	d, err := blocks[0].Timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, "1m0s", d.String())
+
+	assert.Equal(t, "shell", blocks[1].Lang())
+	assert.Equal(t, "first", blocks[1].UniqName())
+	assert.True(t, blocks[1].HasLabel("second"))
+	n, err := blocks[1].Retries()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestInheritingLabels(t *testing.T) {
+	const content = `<!-- @k8s -->
+
+# Install
+
+<!-- @install @timeout=60s -->
+
+Install it.
+
+` + "```" + `
+echo one
+` + "```" + `
+
+## From source
+
+<!-- @slow -->
+
+<!-- @build @timeout=5m -->
+` + "```" + `
+echo two
+` + "```" + `
+
+# Cleanup
+
+<!-- @cleanup -->
+
+Clean up.
+
+` + "```" + `
+echo three
+` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("scopes", []byte(content)).Accept(p)
+	blocks := p.RenderedMdFiles()[0].Blocks
+	if !assert.Equal(t, 3, len(blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "install", blocks[0].UniqName())
+	assert.Equal(t,
+		loader.LabelList{"install", "k8s"}, blocks[0].Labels())
+	d, err := blocks[0].Timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, "1m0s", d.String())
+
+	assert.Equal(t, "build", blocks[1].UniqName())
+	assert.Equal(t,
+		loader.LabelList{"build", "slow", "install", "k8s"}, blocks[1].Labels())
+	d, err = blocks[1].Timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, "5m0s", d.String())
+
+	assert.Equal(t,
+		loader.LabelList{"cleanup", "k8s"}, blocks[2].Labels())
+	assert.True(t, blocks[2].IsTeardown())
+	assert.False(t, blocks[2].HasParam(loader.TimeoutParam))
+}
+
+func TestRecordingHeadings(t *testing.T) {
+	const content = "```" + `
+echo before
+` + "```" + `
+
+# Install
+
+## Linux
+
+### From source
+
+` + "```" + `
+make install
+` + "```" + `
+
+Setext heading
+--------------
+
+> ` + "```" + `
+> echo quoted
+> ` + "```" + `
+
+- item
+
+  ` + "```" + `
+  echo listed
+  ` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("headings", []byte(content)).Accept(p)
+	blocks := p.RenderedMdFiles()[0].Blocks
+	if !assert.Equal(t, 3, len(blocks)) {
+		t.FailNow()
+	}
+	assert.Empty(t, blocks[0].Headings())
+	assert.Equal(t, "echoBefor", blocks[0].UniqName())
+
+	assert.Equal(t,
+		[]string{"Install", "Linux", "From source"}, blocks[1].Headings())
+	assert.Equal(t, "Install > Linux > From source", blocks[1].HeadingPath())
+	assert.Equal(t, "fromSource", blocks[1].UniqName())
+
+	assert.Equal(t, "Install > Setext heading", blocks[2].HeadingPath())
+	assert.Equal(t, "setextHeading", blocks[2].UniqName())
+}
+
+func TestNamingFromEmptyHeadings(t *testing.T) {
+	for heading, want := range map[string]string{
+		"# A":    "echoHello",
+		"# 1.2":  "echoHello",
+		"# --- ": "echoHello",
+		"# Run":  "run",
+	} {
+		t.Run(heading, func(t *testing.T) {
+			p := NewGParser()
+			content := heading + "\n\n```\necho hello\n```\n"
+			loader.NewFile("f.md", []byte(content)).Accept(p)
+			blocks := p.RenderedMdFiles()[0].Blocks
+			if assert.Equal(t, 1, len(blocks)) {
+				assert.Equal(t, want, blocks[0].UniqName())
+			}
+		})
+	}
+}
+
+func TestRecordingPositions(t *testing.T) {
+	const content = `# Positions
+
+` + "```" + `
+echo one
+echo two
+` + "```" + `
+
+- item
+
+  ` + "```" + `
+  echo three
+  ` + "```" + `
+`
+	p := NewGParser()
+	loader.NewFile("positions.md", []byte(content)).Accept(p)
+	blocks := p.RenderedMdFiles()[0].Blocks
+	if !assert.Equal(t, 2, len(blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, 4, blocks[0].Line())
+	assert.Equal(t, 5, blocks[0].EndLine())
+	assert.Equal(t, 1, blocks[0].Column())
+	assert.Equal(t, "positions.md:4", blocks[0].Location())
+
+	assert.Equal(t, 11, blocks[1].Line())
+	assert.Equal(t, 11, blocks[1].EndLine())
+	assert.Equal(t, 3, blocks[1].Column())
+}
+
+func TestParsingFrontMatter(t *testing.T) {
+	const content = `---
+title: Installing
+labels: [linux]
+skip: true
+timeout: 5m
+---
+# Install
+
+` + "```" + `
+make install
+` + "```" + `
+`
+	p := NewGParser()
+	fi := loader.NewFile("fm.md", []byte(content))
+	fi.Accept(p)
+	assert.NoError(t, p.Error())
+	rf := p.RenderedMdFiles()[0]
+	if !assert.NotNil(t, rf.FrontMatter) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Installing", rf.FrontMatter.Title)
+	assert.Same(t, rf.FrontMatter, fi.FrontMatter())
+	assert.Equal(t, "Installing", fi.Title())
+	assert.NotContains(t, string(rf.Html), "title:")
+	assert.Contains(t, string(rf.Html), `<h1 id="install">Install</h1>`)
+
+	b := rf.Blocks[0]
+	assert.Equal(t, 10, b.Line())
+	assert.Equal(t, "install", b.UniqName())
+	assert.True(t, b.HasLabel("linux"))
+	assert.True(t, b.HasLabel(loader.SkipLabel))
+	d, err := b.Timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, "5m0s", d.String())
+}
+
+func TestParsingThematicBreaksAtTop(t *testing.T) {
+	p := NewGParser()
+	fi := loader.NewFile("hr.md", []byte("---\nNot: [front matter\n---\n# Hello\n"))
+	fi.Accept(p)
+	assert.NoError(t, p.Error())
+	assert.Nil(t, fi.FrontMatter())
+	html := string(p.RenderedMdFiles()[0].Html)
+	assert.Contains(t, html, "<hr>")
+	assert.Contains(t, html, "Not: [front matter")
+}
+
+func TestParsingBadFrontMatter(t *testing.T) {
+	p := NewGParser()
+	loader.NewFile("fm.md", []byte("---\ntimeout: soon\n---\n")).Accept(p)
+	assert.ErrorContains(t, p.Error(), "bad front matter")
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package runner_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFifoRunner(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "blocks")
+	if !assert.NoError(t, syscall.Mkfifo(p, 0600)) {
+		t.FailNow()
+	}
+	r, err := New("fifo:" + p)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// With no reader, the write fails rather than hangs.
+	_, err = r.Write([]byte("echo hi\n"))
+	assert.Error(t, err)
+
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+	n, err := r.Write([]byte("echo hi\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	b, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "echo hi\n", string(b))
+}
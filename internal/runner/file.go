@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fifoRunner writes blocks to a named pipe, to be read by a shell
+// started with, say,
+//
+//	mkfifo /tmp/blocks
+//	bash < <(while :; do cat /tmp/blocks; done)
+type fifoRunner struct {
+	path string
+}
+
+func makeFifo(target string) (Runner, error) {
+	if target == "" {
+		return nil, errors.New("name the pipe, e.g. fifo:/tmp/blocks")
+	}
+	return &fifoRunner{path: target}, nil
+}
+
+func (r *fifoRunner) Check() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("%s isn't a named pipe; make one with 'mkfifo'", r.path)
+	}
+	return nil
+}
+
+func (r *fifoRunner) Write(b []byte) (int, error) {
+	// Without O_NONBLOCK, the open would hang until something reads
+	// the pipe; with it, the open fails at once.
+	f, err := os.OpenFile(r.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return 0, fmt.Errorf("nothing is reading %s; %w", r.path, err)
+	}
+	defer f.Close()
+	return f.Write(b)
+}
+
+// fileRunner appends blocks to a file, for whatever might follow it,
+// e.g. 'tail -f', or a record of the blocks run in a demo.
+type fileRunner struct {
+	path string
+}
+
+func makeFile(target string) (Runner, error) {
+	if target == "" {
+		return nil, errors.New("name the file, e.g. file:/tmp/blocks.sh")
+	}
+	return &fileRunner{path: target}, nil
+}
+
+func (r *fileRunner) Check() error {
+	fi, err := os.Stat(filepath.Dir(r.path))
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s isn't a directory", filepath.Dir(r.path))
+	}
+	return nil
+}
+
+func (r *fileRunner) Write(b []byte) (int, error) {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.Write(b)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	return n, err
+}
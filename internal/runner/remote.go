@@ -0,0 +1,76 @@
+package runner
+
+import "os"
+
+// wezTermRunner sends blocks to a WezTerm pane by remote control.
+type wezTermRunner struct {
+	path string
+	pane string
+}
+
+func makeWezTerm(target string) (Runner, error) {
+	p, err := lookPath("wezterm")
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		target = os.Getenv("WEZTERM_PANE")
+	}
+	return &wezTermRunner{path: p, pane: target}, nil
+}
+
+func (r *wezTermRunner) Check() error {
+	_, err := run(nil, r.path, "cli", "list")
+	return err
+}
+
+func (r *wezTermRunner) Write(b []byte) (int, error) {
+	// Without --no-paste, the text arrives as a bracketed paste,
+	// which a shell won't run until Enter is pressed.
+	args := []string{"cli", "send-text", "--no-paste"}
+	if r.pane != "" {
+		args = append(args, "--pane-id", r.pane)
+	}
+	if _, err := run(b, r.path, args...); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// kittyRunner sends blocks to the active kitty window by remote
+// control, which kitty must allow, e.g. with 'allow_remote_control'.
+type kittyRunner struct {
+	path    string
+	address string
+}
+
+func makeKitty(target string) (Runner, error) {
+	p, err := lookPath("kitty")
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		target = os.Getenv("KITTY_LISTEN_ON")
+	}
+	return &kittyRunner{path: p, address: target}, nil
+}
+
+func (r *kittyRunner) args(args ...string) []string {
+	res := []string{"@"}
+	if r.address != "" {
+		res = append(res, "--to", r.address)
+	}
+	return append(res, args...)
+}
+
+func (r *kittyRunner) Check() error {
+	_, err := run(nil, r.path, r.args("ls")...)
+	return err
+}
+
+func (r *kittyRunner) Write(b []byte) (int, error) {
+	if _, err := run(b, r.path, r.args("send-text", "--stdin")...); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,141 @@
+// Package runner holds the places the web app can send code blocks
+// to be run, e.g. a tmux pane, a GNU screen window, or a named pipe
+// read by a shell.  Each is registered under a name selectable with
+// the serve command's --runner flag.
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Runner sends code blocks somewhere to be run.
+type Runner interface {
+	io.Writer
+	// Check returns an error saying why the runner can't run blocks,
+	// e.g. its program isn't installed, or isn't running.
+	Check() error
+}
+
+// maker makes a Runner from a target, e.g. a session name or file path.
+type maker struct {
+	make func(target string) (Runner, error)
+	// usage describes the spec, including its target.
+	usage string
+}
+
+var makers = map[string]maker{
+	"tmux":    {makeTmux, "tmux, the running tmux server, with a window per block session"},
+	"screen":  {makeScreen, "screen[:SESSION], GNU screen, by default the only session"},
+	"zellij":  {makeZellij, "zellij[:SESSION], by default $ZELLIJ_SESSION_NAME"},
+	"wezterm": {makeWezTerm, "wezterm[:PANE_ID], by default $WEZTERM_PANE"},
+	"kitty":   {makeKitty, "kitty[:ADDRESS], e.g. unix:/tmp/kitty, by default $KITTY_LISTEN_ON"},
+	"fifo":    {makeFifo, "fifo:PATH, a named pipe read by a shell"},
+	"file":    {makeFile, "file:PATH, a file the blocks are appended to"},
+}
+
+// probeOrder lists the runners tried by Find.  Runners writing to
+// files need a target, so they're never guessed.
+var probeOrder = []string{"tmux", "screen", "zellij", "wezterm", "kitty"}
+
+// Names returns the names of all runners.
+func Names() []string {
+	res := make([]string, 0, len(makers))
+	for n := range makers {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Usage describes the runner specs accepted by New.
+func Usage() string {
+	var b strings.Builder
+	for _, n := range Names() {
+		b.WriteString("\n  " + makers[n].usage)
+	}
+	return b.String()
+}
+
+// New returns the runner given by a spec of the form NAME or
+// NAME:TARGET, after checking that it's able to run blocks.
+func New(spec string) (Runner, error) {
+	name, target, _ := strings.Cut(spec, ":")
+	m, ok := makers[name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown runner %q; use one of %s", name, strings.Join(Names(), ", "))
+	}
+	r, err := m.make(target)
+	if err == nil {
+		err = r.Check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("runner %q unavailable; %w", spec, err)
+	}
+	return r, nil
+}
+
+// Find returns the first available runner in probeOrder, or an error
+// saying why each of them is unavailable.
+func Find() (Runner, error) {
+	var errs []error
+	for _, n := range probeOrder {
+		r, err := New(n)
+		if err == nil {
+			return r, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Disabled returns a Runner that refuses to run blocks, so that a
+// server lacking a runner can still show its markdown.  The reason
+// is left to Check, since it may be too long to show with each block.
+func Disabled(reason error) Runner {
+	return disabled{reason}
+}
+
+type disabled struct {
+	reason error
+}
+
+func (d disabled) Check() error {
+	return d.reason
+}
+
+func (d disabled) Write([]byte) (int, error) {
+	return 0, errors.New(
+		"no runner, so blocks can't be run; see the server's log")
+}
+
+// lookPath returns the path of a program, or an error saying it's
+// not installed.
+func lookPath(pgm string) (string, error) {
+	p, err := exec.LookPath(pgm)
+	if err != nil {
+		return "", fmt.Errorf("%s not installed; %w", pgm, err)
+	}
+	return p, nil
+}
+
+// run runs a program, feeding it stdin if not nil, and returns its
+// output, or an error including the output.
+func run(stdin []byte, pgm string, args ...string) ([]byte, error) {
+	cmd := exec.Command(pgm, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed; out=%q; %w",
+			pgm, strings.Join(args, " "), bytes.TrimSpace(out), err)
+	}
+	return out, nil
+}
@@ -0,0 +1,57 @@
+package runner_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames(t *testing.T) {
+	assert.Equal(t,
+		[]string{"fifo", "file", "kitty", "screen", "tmux", "wezterm", "zellij"},
+		Names())
+	assert.Contains(t, Usage(), "fifo:PATH,")
+}
+
+func TestNewBadSpecs(t *testing.T) {
+	for name, spec := range map[string]string{
+		"unknown":     "vim",
+		"noFile":      "file",
+		"noFifo":      "fifo",
+		"noDir":       "file:/hopefully/not/a/dir/blocks.sh",
+		"notFifo":     "fifo:" + os.TempDir(),
+		"tmuxTarget":  "tmux:window",
+		"missingFifo": "fifo:/hopefully/not/a/pipe",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(spec)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFileRunner(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "blocks.sh")
+	r, err := New("file:" + f)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for _, s := range []string{"echo hi\n", "echo there\n"} {
+		n, err := r.Write([]byte(s))
+		assert.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+	b, err := os.ReadFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "echo hi\necho there\n", string(b))
+}
+
+func TestDisabled(t *testing.T) {
+	r := Disabled(os.ErrNotExist)
+	assert.ErrorIs(t, r.Check(), os.ErrNotExist)
+	_, err := r.Write([]byte("echo hi\n"))
+	assert.Error(t, err)
+}
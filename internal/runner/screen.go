@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// screenChunk bounds the size of the text given to one "stuff"
+// command, since screen limits the size of the messages it's sent.
+const screenChunk = 512
+
+// screenRunner types blocks into a GNU screen window with
+// 'screen -X stuff'.
+type screenRunner struct {
+	path    string
+	session string
+}
+
+func makeScreen(target string) (Runner, error) {
+	p, err := lookPath("screen")
+	if err != nil {
+		return nil, err
+	}
+	return &screenRunner{path: p, session: target}, nil
+}
+
+func (r *screenRunner) args(args ...string) []string {
+	if r.session == "" {
+		return args
+	}
+	return append([]string{"-S", r.session}, args...)
+}
+
+func (r *screenRunner) Check() error {
+	// screen -ls exits non-zero even when it finds sessions,
+	// so look at what it says instead.
+	args := []string{"-ls"}
+	if r.session != "" {
+		args = append(args, r.session)
+	}
+	out, _ := run(nil, r.path, args...)
+	if bytes.Contains(out, []byte("No Sockets found")) || len(out) == 0 {
+		return errors.New("no screen session found; start one with 'screen'")
+	}
+	return nil
+}
+
+func (r *screenRunner) Write(b []byte) (int, error) {
+	for i := 0; i < len(b); i += screenChunk {
+		chunk := b[i:min(i+screenChunk, len(b))]
+		if _, err := run(
+			nil, r.path, r.args("-X", "stuff", screenEscape(string(chunk)))...); err != nil {
+			return i, err
+		}
+	}
+	return len(b), nil
+}
+
+// screenEscape protects the characters that screen interprets in
+// the arguments of its commands.
+func screenEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `^`, `\^`, `$`, `\$`).Replace(s)
+}
@@ -0,0 +1,34 @@
+package runner
+
+import (
+	"errors"
+
+	"github.com/monopole/mdrip/v2/internal/tmux"
+)
+
+// tmuxRunner pastes blocks into tmux.  Embedding Tmux brings along
+// ForSession, so blocks with a session parameter get their own window.
+type tmuxRunner struct {
+	*tmux.Tmux
+}
+
+func makeTmux(target string) (Runner, error) {
+	if target != "" {
+		return nil, errors.New("tmux takes no target; use a block's session parameter")
+	}
+	if _, err := lookPath(tmux.PgmName); err != nil {
+		return nil, err
+	}
+	tx, err := tmux.NewTmux(tmux.PgmName)
+	if err != nil {
+		return nil, err
+	}
+	return &tmuxRunner{tx}, nil
+}
+
+func (r *tmuxRunner) Check() error {
+	if !r.IsUp() {
+		return errors.New(tmux.PgmName + " installed, but not running")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// zellijRunner types blocks into the focused pane of a zellij session.
+type zellijRunner struct {
+	path    string
+	session string
+}
+
+func makeZellij(target string) (Runner, error) {
+	p, err := lookPath("zellij")
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		target = os.Getenv("ZELLIJ_SESSION_NAME")
+	}
+	if target == "" {
+		return nil, errors.New("name a session, e.g. zellij:NAME; see 'zellij list-sessions'")
+	}
+	return &zellijRunner{path: p, session: target}, nil
+}
+
+func (r *zellijRunner) Check() error {
+	out, err := run(nil, r.path, "list-sessions", "--short")
+	if err != nil {
+		return err
+	}
+	for _, s := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(s) == r.session {
+			return nil
+		}
+	}
+	return fmt.Errorf("no zellij session named %q", r.session)
+}
+
+func (r *zellijRunner) Write(b []byte) (int, error) {
+	if _, err := run(nil, r.path,
+		"--session", r.session, "action", "write-chars", string(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,353 @@
+// Package shellrunner runs code blocks in bash shells on the local
+// machine, reporting their output line by line, so that a web app can
+// show the output of blocks without the help of tmux.
+package shellrunner
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/utils"
+	"github.com/monopole/shexec"
+	"github.com/monopole/shexec/channeler"
+)
+
+const (
+	durationStartup  = 10 * time.Second
+	durationShutdown = 3 * time.Second
+
+	unlikelyWord    = "rumpleStiltSkin"
+	unlikelyWordOut = unlikelyWord + "Out"
+	unlikelyWordErr = unlikelyWord + "Err"
+
+	// statusMarker precedes the exit status of a block in the shell's
+	// stdout.  It's written by the shell, not by the block.
+	statusMarker = utils.PgmName + "ExitStatus="
+	// statusVar holds the exit status while the epilogue runs.
+	statusVar = utils.PgmName + "Status"
+
+	// UnknownStatus is the status of a block whose shell died.
+	UnknownStatus = -1
+)
+
+// ShellRunner keeps a bash shell for each key, e.g. a browser session,
+// and runs code blocks in them.  Shells are started on demand, and
+// stopped when unused for a while.  A shell keeps its variables and
+// working directory from block to block, as a terminal would.
+type ShellRunner struct {
+	// timeout is the max time a block may run.
+	timeout time.Duration
+	// idle is how long a shell may go unused before it's stopped.
+	idle time.Duration
+	// mu guards shells, and the busy field of each.
+	mu     sync.Mutex
+	shells map[string]*shell
+}
+
+// shell is a bash shell, with what's needed to kill it, and to stop
+// it when idle.
+type shell struct {
+	shexec.Shell
+	// pid is the pid of bash.
+	pid int
+	// busy is true while the shell runs a block.
+	busy bool
+	// idleTimer stops the shell when it's gone unused for too long.
+	idleTimer *time.Timer
+}
+
+var _ io.Writer = &ShellRunner{}
+
+// New returns a ShellRunner that lets blocks run for no longer than
+// the given timeout, unless a block's own timeout says otherwise,
+// and stops shells that go unused for the given idle time.
+func New(timeout, idle time.Duration) *ShellRunner {
+	return &ShellRunner{
+		timeout: timeout, idle: idle, shells: make(map[string]*shell)}
+}
+
+// Write runs the bytes in the default shell, discarding output.
+func (sr *ShellRunner) Write(bytes []byte) (int, error) {
+	sh, err := sr.shellFor("")
+	if err != nil {
+		return 0, err
+	}
+	if err = sh.Run(sr.timeout, &shexec.DiscardCommander{C: string(bytes)}); err != nil {
+		sr.forget("", sh)
+		return 0, err
+	}
+	sr.release(sh)
+	return len(bytes), nil
+}
+
+// RunBlock runs the block in the shell for the given key, passing each
+// line of output to the given func as it arrives.  It returns the exit
+// status of the block's last command.  If the block ends its shell,
+// e.g. by calling exit, or runs out of time, the status is
+// UnknownStatus, and the error says why; the next block for the
+// key gets a fresh shell.
+func (sr *ShellRunner) RunBlock(
+	key string, b *loader.CodeBlock,
+	out func(isErr bool, line string)) (int, error) {
+	timeout, err := b.Timeout()
+	if err != nil {
+		return UnknownStatus, err
+	}
+	if timeout == 0 {
+		timeout = sr.timeout
+	}
+	sh, err := sr.shellFor(key)
+	if err != nil {
+		return UnknownStatus, err
+	}
+	// Output of stdout and stderr arrives on separate goroutines,
+	// which, if the shell dies, may outlive the call to Run.
+	var (
+		mu   sync.Mutex
+		done bool
+	)
+	c := &streamCommander{
+		c: makeCommand(b), status: UnknownStatus,
+		out: func(isErr bool, line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !done {
+				out(isErr, line)
+			}
+		}}
+	err = sh.Run(timeout, c)
+	mu.Lock()
+	done = true
+	mu.Unlock()
+	if err != nil {
+		sr.forget(key, sh)
+		return UnknownStatus, fmt.Errorf(
+			"the shell ended or timed out, so the next block gets a new one; %w", err)
+	}
+	sr.release(sh)
+	return c.status, nil
+}
+
+// Stop stops all the shells.
+func (sr *ShellRunner) Stop() {
+	sr.mu.Lock()
+	shells := sr.shells
+	sr.shells = make(map[string]*shell)
+	sr.mu.Unlock()
+	for _, sh := range shells {
+		sh.stop()
+	}
+}
+
+// makeCommand returns the block's script followed by
+// a report of the exit status of its body.
+func makeCommand(b *loader.CodeBlock) string {
+	prologue, epilogue := b.ScriptParts()
+	body := b.Body()
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	return prologue + body +
+		statusVar + "=$?\n" +
+		epilogue +
+		"echo \"" + statusMarker + "$" + statusVar + "\"\n"
+}
+
+// shellFor returns the shell of the key, starting one if need be,
+// and marks it busy until released or forgotten.  Since starting a
+// shell is slow, it's done without holding the lock.
+func (sr *ShellRunner) shellFor(key string) (*shell, error) {
+	if sh := sr.claim(key); sh != nil {
+		return sh, nil
+	}
+	sh, err := startShell()
+	if err != nil {
+		return nil, err
+	}
+	sh.idleTimer = time.AfterFunc(sr.idle, func() { sr.expire(key, sh) })
+	sh.idleTimer.Stop()
+	sr.mu.Lock()
+	if other, ok := sr.shells[key]; ok {
+		// Another request started a shell for the key meanwhile.
+		other.busy = true
+		other.idleTimer.Stop()
+		sr.mu.Unlock()
+		sh.stop()
+		return other, nil
+	}
+	sr.shells[key] = sh
+	sr.mu.Unlock()
+	return sh, nil
+}
+
+// claim returns the shell of the key, marked busy, or nil if
+// the key has no shell.
+func (sr *ShellRunner) claim(key string) *shell {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sh, ok := sr.shells[key]
+	if !ok {
+		return nil
+	}
+	sh.busy = true
+	sh.idleTimer.Stop()
+	return sh
+}
+
+// startShell starts bash, returning it as a busy shell.
+func startShell() (*shell, error) {
+	bash, err := utils.StartShell(shexec.Parameters{
+		Params: channeler.Params{Path: "/bin/bash"},
+		SentinelOut: shexec.Sentinel{
+			C: "echo " + unlikelyWordOut,
+			V: unlikelyWordOut,
+		},
+		SentinelErr: shexec.Sentinel{
+			C: "echo " + unlikelyWordErr + " 1>&2",
+			V: unlikelyWordErr,
+		},
+	}, durationStartup)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start shell; %w", err)
+	}
+	pid := shexec.NewRecallCommander("echo $$")
+	if err = bash.Run(durationStartup, pid); err != nil {
+		_ = bash.Stop(durationShutdown, "")
+		return nil, fmt.Errorf("unable to get pid of shell; %w", err)
+	}
+	sh := &shell{Shell: bash, busy: true}
+	if sh.pid, err = strconv.Atoi(strings.Join(pid.DataOut(), "")); err != nil {
+		_ = bash.Stop(durationShutdown, "")
+		return nil, fmt.Errorf("unable to get pid of shell; %w", err)
+	}
+	return sh, nil
+}
+
+// release marks the shell as no longer busy, starting its idle time.
+func (sr *ShellRunner) release(sh *shell) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sh.busy = false
+	sh.idleTimer.Reset(sr.idle)
+}
+
+// expire stops the shell of the key, unless it's been put to use
+// since its idle time ran out.
+func (sr *ShellRunner) expire(key string, sh *shell) {
+	sr.mu.Lock()
+	if sh.busy || sr.shells[key] != sh {
+		sr.mu.Unlock()
+		return
+	}
+	delete(sr.shells, key)
+	sr.mu.Unlock()
+	slog.Debug("stopping idle shell", "key", key)
+	sh.stop()
+}
+
+// forget drops the shell of the key, which failed to run a block,
+// and kills it, along with anything the block left running.
+func (sr *ShellRunner) forget(key string, sh *shell) {
+	sr.mu.Lock()
+	if sr.shells[key] == sh {
+		delete(sr.shells, key)
+	}
+	sr.mu.Unlock()
+	sh.stop()
+}
+
+// stop stops the shell, killing it and its descendants if it can't be
+// stopped, e.g. because a block ran out of time or ended the shell.
+func (sh *shell) stop() {
+	sh.idleTimer.Stop()
+	if err := sh.Stop(durationShutdown, ""); err == nil {
+		return
+	}
+	if err := killTree(sh.pid); err != nil {
+		slog.Warn("unable to kill shell", "pid", sh.pid, "err", err)
+	}
+}
+
+// killTree kills the process with the given pid and its descendants.
+func killTree(pid int) error {
+	out, err := exec.Command("ps", "-A", "-o", "pid=,ppid=").Output()
+	if err != nil {
+		return fmt.Errorf("unable to list processes; %w", err)
+	}
+	kids := make(map[int][]int)
+	alive := false
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			continue
+		}
+		p, err1 := strconv.Atoi(f[0])
+		pp, err2 := strconv.Atoi(f[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		kids[pp] = append(kids[pp], p)
+		alive = alive || p == pid
+	}
+	if !alive {
+		return nil
+	}
+	pids := []string{strconv.Itoa(pid)}
+	for todo := []int{pid}; len(todo) > 0; todo = todo[1:] {
+		for _, k := range kids[todo[0]] {
+			pids = append(pids, strconv.Itoa(k))
+			todo = append(todo, k)
+		}
+	}
+	// Some may be gone already, so ignore the exit status.
+	_ = exec.Command("kill", append([]string{"-s", "KILL", "--"}, pids...)...).Run()
+	return nil
+}
+
+// streamCommander passes output lines to a func, minus the
+// exit status, which it remembers.
+type streamCommander struct {
+	c      string
+	status int
+	out    func(isErr bool, line string)
+}
+
+func (c *streamCommander) Command() string { return c.c }
+
+func (c *streamCommander) ParseOut() io.WriteCloser {
+	return lineFunc(func(line string) {
+		before, after, found := strings.Cut(line, statusMarker)
+		if !found {
+			c.out(false, line)
+			return
+		}
+		if before != "" {
+			// The block's output didn't end with a newline.
+			c.out(false, before)
+		}
+		if n, err := strconv.Atoi(after); err == nil {
+			c.status = n
+		}
+	})
+}
+
+func (c *streamCommander) ParseErr() io.WriteCloser {
+	return lineFunc(func(line string) { c.out(true, line) })
+}
+
+// lineFunc is a WriteCloser that passes each write, a line, to a func.
+type lineFunc func(line string)
+
+func (f lineFunc) Write(data []byte) (int, error) {
+	f(string(data))
+	return len(data), nil
+}
+
+func (f lineFunc) Close() error { return nil }
@@ -0,0 +1,181 @@
+package shellrunner_test
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	. "github.com/monopole/mdrip/v2/internal/shellrunner"
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	out []string
+	err []string
+}
+
+func (r *recorder) record(isErr bool, line string) {
+	if isErr {
+		r.err = append(r.err, line)
+	} else {
+		r.out = append(r.out, line)
+	}
+}
+
+func TestRunBlock(t *testing.T) {
+	sr := New(10*time.Second, time.Hour)
+	defer sr.Stop()
+
+	var r recorder
+	status, err := sr.RunBlock("a", loader.NewCodeBlock(nil,
+		"greeting=hello\necho $greeting\necho oops >&2\n", 0), r.record)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, []string{"hello"}, r.out)
+	assert.Equal(t, []string{"oops"}, r.err)
+
+	// The shell of the key remembers variables.
+	r = recorder{}
+	status, err = sr.RunBlock("a", loader.NewCodeBlock(nil,
+		"printf $greeting\nfalse", 0), r.record)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status)
+	assert.Equal(t, []string{"hello"}, r.out)
+
+	// Another key gets another shell.
+	r = recorder{}
+	_, err = sr.RunBlock("b", loader.NewCodeBlock(nil,
+		"echo \"[$greeting]\"\n", 0), r.record)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[]"}, r.out)
+
+	// A block that ends its shell has no status, and
+	// the next block gets a fresh shell.
+	status, err = sr.RunBlock("a", loader.NewCodeBlock(nil, "exit 3\n", 0), r.record)
+	assert.Error(t, err)
+	assert.Equal(t, UnknownStatus, status)
+	r = recorder{}
+	status, err = sr.RunBlock("a", loader.NewCodeBlock(nil,
+		"echo \"[$greeting]\"\n", 0), r.record)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, []string{"[]"}, r.out)
+}
+
+// isAlive is true if the process exists, and isn't a zombie.
+func isAlive(pid int) bool {
+	out, err := exec.Command("ps", "-o", "stat=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		// ps fails if there's no such process.
+		return false
+	}
+	stat := strings.TrimSpace(string(out))
+	return stat != "" && !strings.HasPrefix(stat, "Z")
+}
+
+// pids returns the pids printed by a block.
+func pids(t *testing.T, out []string) []int {
+	var result []int
+	for _, line := range out {
+		pid, err := strconv.Atoi(line)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		result = append(result, pid)
+	}
+	return result
+}
+
+func TestTimedOutShellIsKilled(t *testing.T) {
+	sr := New(time.Second, time.Hour)
+	defer sr.Stop()
+
+	var r recorder
+	status, err := sr.RunBlock("a", loader.NewCodeBlock(nil,
+		"echo $$\nsleep 300 &\necho $!\nsleep 300\n", 0), r.record)
+	assert.Error(t, err)
+	assert.Equal(t, UnknownStatus, status)
+	procs := pids(t, r.out)
+	if !assert.Len(t, procs, 2) {
+		return
+	}
+	for _, pid := range procs {
+		assert.Eventually(t, func() bool { return !isAlive(pid) },
+			5*time.Second, 50*time.Millisecond, "pid %d lives", pid)
+	}
+}
+
+func TestIdleShellIsStopped(t *testing.T) {
+	sr := New(10*time.Second, 500*time.Millisecond)
+	defer sr.Stop()
+
+	var r recorder
+	_, err := sr.RunBlock("a", loader.NewCodeBlock(nil,
+		"echo $$\ngreeting=hello\n", 0), r.record)
+	assert.NoError(t, err)
+	procs := pids(t, r.out)
+	if !assert.Len(t, procs, 1) {
+		return
+	}
+	assert.True(t, isAlive(procs[0]))
+	assert.Eventually(t, func() bool { return !isAlive(procs[0]) },
+		5*time.Second, 50*time.Millisecond)
+
+	// The next block gets a fresh shell.
+	r = recorder{}
+	_, err = sr.RunBlock("a", loader.NewCodeBlock(nil,
+		"echo \"[$greeting]\"\n", 0), r.record)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[]"}, r.out)
+}
+
+func TestBusyShellIsNotStopped(t *testing.T) {
+	sr := New(10*time.Second, 200*time.Millisecond)
+	defer sr.Stop()
+
+	var r recorder
+	// The block outlasts the idle time.
+	status, err := sr.RunBlock("a", loader.NewCodeBlock(nil,
+		"greeting=hello\nsleep 1\necho $greeting\n", 0), r.record)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, []string{"hello"}, r.out)
+}
+
+func TestShellsStartConcurrently(t *testing.T) {
+	sr := New(10*time.Second, time.Hour)
+	const numKeys = 5
+	var (
+		wg    sync.WaitGroup
+		procs [numKeys][]int
+	)
+	for i := range numKeys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var r recorder
+			_, err := sr.RunBlock(strconv.Itoa(i),
+				loader.NewCodeBlock(nil, "echo $$\n", 0), r.record)
+			assert.NoError(t, err)
+			procs[i] = pids(t, r.out)
+		}()
+	}
+	wg.Wait()
+	seen := make(map[int]bool)
+	for _, p := range procs {
+		if assert.Len(t, p, 1) {
+			assert.True(t, isAlive(p[0]))
+			seen[p[0]] = true
+		}
+	}
+	assert.Len(t, seen, numKeys, "each key has its own shell")
+	sr.Stop()
+	for pid := range seen {
+		assert.Eventually(t, func() bool { return !isAlive(pid) },
+			5*time.Second, 50*time.Millisecond, "pid %d lives", pid)
+	}
+}
@@ -0,0 +1,53 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Supported is true if this package can make a pseudo-terminal here.
+const Supported = true
+
+// startInPty starts the command with a new pseudo-terminal as its
+// stdin, stdout, stderr and controlling terminal, and returns the
+// controlling side of the pseudo-terminal.
+func startInPty(cmd *exec.Cmd) (*os.File, error) {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(ptmx.Fd())
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		_ = ptmx.Close()
+		return nil, fmt.Errorf("unable to unlock pty; %w", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		_ = ptmx.Close()
+		return nil, fmt.Errorf("unable to name pty; %w", err)
+	}
+	tty, err := os.OpenFile(
+		fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = ptmx.Close()
+		return nil, err
+	}
+	// The command has its own copy now.
+	defer tty.Close()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err = cmd.Start(); err != nil {
+		_ = ptmx.Close()
+		return nil, err
+	}
+	return ptmx, nil
+}
+
+func setSize(f *os.File, rows, cols int) error {
+	return unix.IoctlSetWinsize(int(f.Fd()), unix.TIOCSWINSZ,
+		&unix.Winsize{Row: uint16(rows), Col: uint16(cols)})
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package terminal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Supported is true if this package can make a pseudo-terminal here.
+// Only Linux is done so far.
+const Supported = false
+
+// errUnsupported is returned where this package can't make a
+// pseudo-terminal.
+var errUnsupported = errors.New("pseudo-terminals aren't supported here")
+
+func startInPty(_ *exec.Cmd) (*os.File, error) {
+	return nil, errUnsupported
+}
+
+func setSize(_ *os.File, _, _ int) error {
+	return errUnsupported
+}
@@ -0,0 +1,196 @@
+// Package terminal runs a shell in a pseudo-terminal, so that a web
+// app can show the shell in a terminal pane, and type code blocks
+// into it, without the help of tmux.
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+const (
+	// maxBacklog is how much recent output is kept
+	// for panes that attach after it was written.
+	maxBacklog = 64 * 1024
+	// subscriberBuffer is how many chunks of output may wait for a
+	// slow pane before the pane is dropped.
+	subscriberBuffer = 256
+)
+
+// Terminal is a shell running in a pseudo-terminal.  Writes are typed
+// into the shell, as if at a keyboard.  The shell starts on the first
+// write, or attachment, and starts again if it exits.
+type Terminal struct {
+	shell string
+	// mu guards all that follows.
+	mu sync.Mutex
+	// pty is the controlling side of the pseudo-terminal,
+	// or nil if the shell isn't running.
+	pty     *os.File
+	cmd     *exec.Cmd
+	backlog []byte
+	subs    map[chan []byte]bool
+	rows    int
+	cols    int
+}
+
+var _ io.Writer = &Terminal{}
+
+// New returns a Terminal that will run the given shell, e.g. /bin/bash.
+func New(shell string) *Terminal {
+	return &Terminal{shell: shell, subs: make(map[chan []byte]bool)}
+}
+
+// Write types the bytes into the shell, starting the shell if need be.
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	if err := t.start(); err != nil {
+		t.mu.Unlock()
+		return 0, err
+	}
+	f := t.pty
+	t.mu.Unlock()
+	// Don't hold mu; the shell may not read more until pump,
+	// which needs mu, takes some of its output.  If pump or Stop
+	// closes f meanwhile, the write fails with os.ErrClosed.
+	n, err := f.Write(p)
+	if errors.Is(err, os.ErrClosed) {
+		return n, fmt.Errorf(
+			"%s exited, or was stopped, before the input was typed; %w",
+			t.shell, err)
+	}
+	return n, err
+}
+
+// Attach starts the shell, if need be, and returns the recent output
+// of the terminal, and a channel of the output to come.  The channel
+// is closed by detach, or if the receiver falls too far behind, in
+// which case the receiver should attach again.
+func (t *Terminal) Attach() (backlog []byte, out <-chan []byte, detach func(), err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err = t.start(); err != nil {
+		return nil, nil, nil, err
+	}
+	ch := make(chan []byte, subscriberBuffer)
+	t.subs[ch] = true
+	detach = func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.unsubscribe(ch)
+	}
+	return append([]byte(nil), t.backlog...), ch, detach, nil
+}
+
+// Resize sets the size of the terminal, which the shell
+// and the programs it runs use to lay out their output.
+func (t *Terminal) Resize(rows, cols int) error {
+	if rows <= 0 || cols <= 0 {
+		return fmt.Errorf("bad terminal size %dx%d", rows, cols)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows, t.cols = rows, cols
+	if t.pty == nil {
+		return nil
+	}
+	return setSize(t.pty, rows, cols)
+}
+
+// Stop ends the shell, and detaches all receivers.
+func (t *Terminal) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cmd != nil && t.cmd.Process != nil {
+		_ = t.cmd.Process.Kill()
+	}
+	if t.pty != nil {
+		_ = t.pty.Close()
+		t.pty = nil
+	}
+	for ch := range t.subs {
+		t.unsubscribe(ch)
+	}
+}
+
+// start starts the shell, unless it's running.  Call with mu held.
+func (t *Terminal) start() error {
+	if t.pty != nil {
+		return nil
+	}
+	cmd := exec.Command(t.shell)
+	// Keep programs from writing much in the way of escape sequences.
+	cmd.Env = append(os.Environ(), "TERM=dumb")
+	f, err := startInPty(cmd)
+	if err != nil {
+		return fmt.Errorf("unable to start %s in a terminal; %w", t.shell, err)
+	}
+	if t.rows > 0 {
+		if err = setSize(f, t.rows, t.cols); err != nil {
+			slog.Warn("unable to size terminal", "err", err)
+		}
+	}
+	t.pty, t.cmd = f, cmd
+	go t.pump(f, cmd)
+	return nil
+}
+
+// pump passes the shell's output to the receivers,
+// until the shell exits.
+func (t *Terminal) pump(f *os.File, cmd *exec.Cmd) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			t.broadcast(append([]byte(nil), buf[:n]...))
+		}
+		if err != nil {
+			break
+		}
+	}
+	_ = cmd.Wait()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pty == f {
+		_ = f.Close()
+		t.pty = nil
+		t.cmd = nil
+	}
+	t.send([]byte("\r\n[" + t.shell + " exited]\r\n"))
+}
+
+func (t *Terminal) broadcast(b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.send(b)
+}
+
+// send records the output, and passes it to the receivers,
+// dropping any that have fallen behind.  Call with mu held.
+func (t *Terminal) send(b []byte) {
+	t.backlog = append(t.backlog, b...)
+	if len(t.backlog) > maxBacklog {
+		t.backlog = t.backlog[len(t.backlog)-maxBacklog:]
+	}
+	for ch := range t.subs {
+		select {
+		case ch <- b:
+		default:
+			slog.Debug("dropping slow terminal receiver")
+			t.unsubscribe(ch)
+		}
+	}
+}
+
+// unsubscribe closes the receiver's channel.  Call with mu held.
+func (t *Terminal) unsubscribe(ch chan []byte) {
+	if t.subs[ch] {
+		delete(t.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package terminal_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/monopole/mdrip/v2/internal/terminal"
+	"github.com/stretchr/testify/assert"
+)
+
+// awaitOutput reads output until it holds the given text.
+func awaitOutput(t *testing.T, out <-chan []byte, text string) string {
+	t.Helper()
+	var got strings.Builder
+	timeout := time.After(10 * time.Second)
+	for !strings.Contains(got.String(), text) {
+		select {
+		case b, ok := <-out:
+			if !ok {
+				t.Fatalf("output closed; got %q", got.String())
+			}
+			got.Write(b)
+		case <-timeout:
+			t.Fatalf("no %q in %q", text, got.String())
+		}
+	}
+	return got.String()
+}
+
+func TestTerminal(t *testing.T) {
+	term := New("/bin/bash")
+	defer term.Stop()
+	assert.NoError(t, term.Resize(24, 100))
+	_, out, detach, err := term.Attach()
+	assert.NoError(t, err)
+
+	_, err = term.Write([]byte("x=6\necho $((x*7)) $COLUMNS\n"))
+	assert.NoError(t, err)
+	awaitOutput(t, out, "42 100")
+
+	// A later receiver gets the output so far.
+	backlog, out2, detach2, err := term.Attach()
+	assert.NoError(t, err)
+	assert.Contains(t, string(backlog), "42 100")
+	detach()
+	_, ok := <-out
+	assert.False(t, ok, "detached")
+
+	// The shell restarts after it exits.
+	_, err = term.Write([]byte("exit\n"))
+	assert.NoError(t, err)
+	awaitOutput(t, out2, "exited]")
+	detach2()
+	_, out, detach, err = term.Attach()
+	assert.NoError(t, err)
+	defer detach()
+	_, err = term.Write([]byte("echo \"[$x]\"\n"))
+	assert.NoError(t, err)
+	awaitOutput(t, out, "[]")
+}
+
+func TestWriteWhileStopping(t *testing.T) {
+	term := New("/bin/bash")
+	defer term.Stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			term.Stop()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	for range 200 {
+		if _, err := term.Write([]byte("true\n")); err != nil {
+			assert.ErrorContains(t, err, "exited, or was stopped")
+		}
+	}
+	<-done
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/monopole/mdrip/v2/internal/utils"
 )
@@ -24,8 +26,17 @@ const (
 	PgmName = "tmux"
 	// SessionName is the string to use when naming a tmux session.
 	SessionName = utils.PgmName
+
+	// newWindowTimeout is how long to wait for a new window's shell to start.
+	newWindowTimeout = 10 * time.Second
+	// newWindowPoll is how often to look for a new window's prompt.
+	newWindowPoll = 50 * time.Millisecond
 )
 
+// windowMu serializes ForSession, so that concurrent calls for
+// the same session don't both make a window.
+var windowMu sync.Mutex
+
 // NewTmux is a ctor.
 func NewTmux(programName string) (*Tmux, error) {
 	p, err := exec.LookPath(programName)
@@ -95,6 +106,70 @@ func (tx Tmux) Write(bytes []byte) (n int, err error) {
 	return len(bytes), nil
 }
 
+// ForSession returns a Tmux that writes to the tmux window named
+// after the given code block session, creating the window if need be.
+//
+// The window is in the session named SessionName if there is one,
+// else in the session holding the default pane.  A new window isn't
+// written to until its shell shows a prompt, lest the paste be
+// swallowed by the shell's initialization.
+func (tx Tmux) ForSession(name string) (io.Writer, error) {
+	windowMu.Lock()
+	defer windowMu.Unlock()
+	session, err := tx.targetSession()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(
+		tx.path, "list-windows", "-t", session, "-F", "#{pane_id} #{window_name}")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tmux windows; %w", err)
+	}
+	for _, w := range strings.Split(string(out), "\n") {
+		if pane, wName, ok := strings.Cut(w, " "); ok && wName == name {
+			return &Tmux{path: tx.path, paneID: pane}, nil
+		}
+	}
+	cmd = exec.Command(tx.path, "new-window", "-d", "-t", session+":",
+		"-n", name, "-P", "-F", "#{pane_id}")
+	if out, err = cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf(
+			"unable to make tmux window %q; out=%q; %w", name, out, err)
+	}
+	w := &Tmux{path: tx.path, paneID: strings.TrimSpace(string(out))}
+	w.awaitPrompt()
+	return w, nil
+}
+
+// targetSession returns the target of the session holding the
+// windows made by ForSession.
+func (tx Tmux) targetSession() (string, error) {
+	exact := "=" + SessionName
+	if exec.Command(tx.path, "has-session", "-t", exact).Run() == nil {
+		return exact, nil
+	}
+	out, err := exec.Command(tx.path,
+		"display-message", "-p", "-t", tx.paneID, "#{session_id}").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to find a tmux session; %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// awaitPrompt waits for the pane to show something, presumably its
+// shell's prompt, or for newWindowTimeout to pass.
+func (tx Tmux) awaitPrompt() {
+	for deadline := time.Now().Add(newWindowTimeout); time.Now().Before(deadline); {
+		out, err := exec.Command(tx.path, "capture-pane", "-p", "-t", tx.paneID).Output()
+		if err == nil && len(strings.TrimSpace(string(out))) > 0 {
+			return
+		}
+		time.Sleep(newWindowPoll)
+	}
+	slog.Warn("no prompt in new tmux window", "pane", tx.paneID)
+}
+
 func (tx Tmux) Start() error {
 	cmd := exec.Command(tx.path, "new-session", "-s", SessionName, "-d")
 	out, err := cmd.Output()
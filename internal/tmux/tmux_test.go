@@ -1,9 +1,14 @@
 package tmux_test
 
 import (
-	. "github.com/monopole/mdrip/v2/internal/tmux"
+	"io"
+	"os/exec"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	. "github.com/monopole/mdrip/v2/internal/tmux"
 )
 
 const (
@@ -19,7 +24,18 @@ func TestBadName(t *testing.T) {
 	}
 }
 
+// isolate points tmux at a server of the test's own, so that
+// a tmux server already running on the host isn't disturbed.
+func isolate(t *testing.T) {
+	t.Setenv("TMUX_TMPDIR", t.TempDir())
+	t.Setenv("TMUX", "")
+	t.Cleanup(func() {
+		_ = exec.Command(PgmName, "kill-server").Run()
+	})
+}
+
 func TestStartAndStopTmuxSession(t *testing.T) {
+	isolate(t)
 	x, err := NewTmux(PgmName)
 	if err != nil {
 		t.Skip(skipNoTmux)
@@ -46,3 +62,71 @@ func TestStartAndStopTmuxSession(t *testing.T) {
 		t.Errorf("unable to stop session: %s", err)
 	}
 }
+
+func TestForSession(t *testing.T) {
+	isolate(t)
+	x, err := NewTmux(PgmName)
+	if err != nil {
+		t.Skip(skipNoTmux)
+	}
+	if x.IsUp() {
+		t.Skip(skipAlreadyRunning)
+	}
+	if err = x.Start(); err != nil {
+		t.Fatalf("unable to start session: %s", err)
+	}
+	defer func() {
+		if err = x.Stop(); err != nil {
+			t.Errorf("unable to stop session: %s", err)
+		}
+	}()
+	// Another, more recently used, session mustn't get the window.
+	if out, err := exec.Command(PgmName, "new-session", "-d", "-s", "other").CombinedOutput(); err != nil {
+		t.Fatalf("unable to start other session: %s; %s", out, err)
+	}
+	var wg sync.WaitGroup
+	writers := make([]io.Writer, 3)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, err := x.ForSession("client")
+			if err != nil {
+				t.Errorf("unable to get window: %s", err)
+			}
+			writers[i] = w
+		}()
+	}
+	wg.Wait()
+	out, err := exec.Command(PgmName, "list-windows", "-a", "-F",
+		"#{session_name} #{window_name} #{pane_id}").Output()
+	if err != nil {
+		t.Fatalf("unable to list windows: %s", err)
+	}
+	var clients []string
+	for _, w := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if f := strings.Fields(w); f[1] == "client" {
+			clients = append(clients, w)
+			if f[0] != SessionName {
+				t.Errorf("window in wrong session: %q", w)
+			}
+		}
+	}
+	if len(clients) != 1 {
+		t.Fatalf("expected one client window, got %q", clients)
+	}
+	if _, err = io.WriteString(writers[0], "echo hello from $((6 * 7))\n"); err != nil {
+		t.Fatalf("unable to write: %s", err)
+	}
+	pane := strings.Fields(clients[0])[2]
+	for i := 0; ; i++ {
+		out, err = exec.Command(PgmName, "capture-pane", "-p", "-t", pane).Output()
+		if err == nil && strings.Contains(string(out), "hello from 42") {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("command not run in window; pane shows %q", out)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
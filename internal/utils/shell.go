@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/monopole/shexec"
+	"github.com/monopole/shexec/channeler"
+)
+
+var (
+	// firstStart serializes starts until one has set shexec's globals.
+	firstStart sync.Mutex
+	// globalsSet is true once shexec's globals are set.
+	globalsSet bool
+)
+
+// StartShell makes and starts a shell, as shexec.NewShell and
+// Start would.
+//
+// Each start of a shell from shexec.NewShell sets shexec's logging
+// globals, racing with the logging of any shells already running.
+// So the first shell comes from shexec.NewShell, to set the globals,
+// and later shells come from shexec.NewShellRaw, which leaves them be.
+func StartShell(p shexec.Parameters, d time.Duration) (shexec.Shell, error) {
+	firstStart.Lock()
+	if !globalsSet {
+		defer firstStart.Unlock()
+		sh := shexec.NewShell(p)
+		err := sh.Start(d)
+		// Valid parameters mean the globals were set, even if
+		// the shell failed to start.
+		globalsSet = p.Validate() == nil
+		return sh, err
+	}
+	firstStart.Unlock()
+	sh := shexec.NewShellRaw(
+		func() (*channeler.Channels, error) {
+			if err := p.Validate(); err != nil {
+				return nil, err
+			}
+			return channeler.Start(&p.Params)
+		},
+		p.SentinelOut,
+		p.SentinelErr,
+	)
+	return sh, sh.Start(d)
+}
@@ -2,6 +2,8 @@ package app
 
 import (
 	_ "embed"
+	"html/template"
+
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/mdrip"
 	"github.com/monopole/mdrip/v2/internal/web/config"
@@ -28,33 +30,56 @@ var (
 	// Don't forget to set the content-type header if you use this.
 	cssViaLink = `<link rel='stylesheet' type='` + MimeCss +
 		`' href='` + config.Dynamic(config.RouteCss) + `' />`
-
-	// Use this instead of cssViaLink to inject directly into the html response.
-	cssInjected = `<style> ` + mdrip.AllCss + ` </style>`
 )
 
 var (
-	html = `
-<!DOCTYPE html>
-<html lang="en">
-  <head>
-    <title>{{.AppState.Title}}</title>
-    ` + cssViaLink + `
-    <script type='` + MimeJs + `' src='` + config.Dynamic(config.RouteJs) + `'></script>
-    <script type='` + MimeJs + `'>
-      function makeEmptyCache() {
+	jsViaLink = `<script type='` + MimeJs + `' src='` +
+		config.Dynamic(config.RouteJs) + `'></script>`
+
+	// The Css and Js fields of StaticParams are injected directly,
+	// so the page needs no server.
+	cssAndJsInjected = `<style>{{.Css}}</style>
+    <script type='` + MimeJs + `'>{{.Js}}</script>`
+
+	html = makeHtml(cssViaLink+"\n    "+jsViaLink, `
+      function makeCache() {
         let c = new Array({{len .AppState.RenderedFiles}});
         for (let i = 0; i < c.length; i++) {
           c[i] = null;
         }
         return c;
-      }
+      }`)
+
+	// staticHtml has every rendered file, so the
+	// session controller never asks a server for one.
+	staticHtml = makeHtml(cssAndJsInjected, `
+      function makeCache() {
+        return {{.AppState.RenderedFiles}}.map((f) => {
+          let names = f.CodeBlockNames || [];
+          return {
+            Html: f.Html,
+            CodeBlockLabels: names,
+            Runnable: f.Runnable || names.map(() => true),
+            CbRunCount: new Array(names.length).fill(0),
+          };
+        });
+      }`)
+)
+
+func makeHtml(head, makeCache string) string {
+	return `
+<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <title>{{.AppState.Title}}</title>
+    ` + head + `
+    <script type='` + MimeJs + `'>` + makeCache + `
       // Define these outside onLoad to allow console access (debugging).
       let sc = null;
       let as = null;
       let nac = null;
       function onLoad() {
-        sc = new SessionController(makeEmptyCache());
+        sc = new SessionController(makeCache());
         as = new AppState(sc, {{.AppState.InitialRender}});
         nac = new MdRipController(as);
         sc.enable();
@@ -68,8 +93,22 @@ var (
   </body>
 </html>
 `
-)
+}
 
 func AsTmpl() string {
 	return mdrip.AsTmplHtml() + common.AsTmpl(TmplName, html)
 }
+
+// StaticParams are the parameters of the static template.
+type StaticParams struct {
+	*mdrip.TmplParams
+	Css template.CSS
+	Js  template.JS
+}
+
+// AsStaticTmpl returns a template, named TmplName, for a page holding
+// the whole web app, for reading without a server.  Execute it with
+// StaticParams.
+func AsStaticTmpl() string {
+	return mdrip.AsTmplHtml() + common.AsTmpl(TmplName, staticHtml)
+}
@@ -38,8 +38,29 @@ type InitialRender struct {
 // a list of code labels, one label (the main label) for each
 // code block in the file.
 type HtmlAndLabels struct {
-	Html           template.HTML
+	Html template.HTML
+	BlockLabels
+}
+
+// BlockLabels has the name of each code block in a file, and
+// whether the block may be run, i.e. passes the block filter.
+type BlockLabels struct {
 	CodeBlockNames []string
+	Runnable       []bool
+}
+
+// NewBlockLabels returns the labels of the blocks; a nil filter
+// lets every block run.
+func NewBlockLabels(
+	blocks []*loader.CodeBlock, filter parsren.BlockFilter) BlockLabels {
+	runnable := make([]bool, len(blocks))
+	for i, b := range blocks {
+		runnable[i] = filter == nil || filter(b)
+	}
+	return BlockLabels{
+		CodeBlockNames: loader.NewBlockNameList(blocks),
+		Runnable:       runnable,
+	}
 }
 
 type AppState struct {
@@ -71,7 +92,8 @@ func (as *AppState) SetInitialFileIndex(p string) {
 }
 
 func New(
-	dSource string, files []*parsren.RenderedMdFile, title string) *AppState {
+	dSource string, files []*parsren.RenderedMdFile, title string,
+	filter parsren.BlockFilter) *AppState {
 	var as AppState
 	as.DataSource = dSource
 	as.Title = title
@@ -84,8 +106,8 @@ func New(
 			maxCodeBlocksInOneFile = len(f.Blocks)
 		}
 		as.RenderedFiles[i] = HtmlAndLabels{
-			Html:           f.Html,
-			CodeBlockNames: loader.NewBlockNameList(f.Blocks),
+			Html:        f.Html,
+			BlockLabels: NewBlockLabels(f.Blocks, filter),
 		}
 	}
 	as.Facts.IsNavVisible = false
@@ -20,6 +20,7 @@ class AppState {
         this.file = {
             Html: "<p> Oops </p>",
             CodeBlockLabels: [],
+            Runnable: [],
             CbRunCount: [],
         };
         this.markdownRoot = document.getElementById("mdFilesRoot");
@@ -27,6 +28,7 @@ class AppState {
         this.layoutReactors = [];
         this.codeBlockChangeReactors = [];
         this.codeBlockRunReactors = [];
+        this.codeBlockOutputReactors = [];
     }
 
     report() {
@@ -44,9 +46,13 @@ class AppState {
 
     runCodeBlock() {
         let index = this.myCodeBlockIndex;
+        let fileIndex = this.myFileIndex;
         this.sessionController.runBlock(
             this.myFileIndex, this.myCodeBlockIndex,
-            () => {this.notifyCodeBlockRunReactors(index);});
+            () => {this.notifyCodeBlockRunReactors(index);},
+            (event, data) => {
+                this.notifyCodeBlockOutputReactors(fileIndex, index, event, data);
+            });
     }
 
     focusMarkdownRoot() {
@@ -86,6 +92,14 @@ class AppState {
         this.codeBlockRunReactors.push(r);
     }
 
+    addCodeBlockOutputReactor(r) {
+        this.codeBlockOutputReactors.push(r);
+    }
+
+    get isStatic() {
+        return this.sessionController.isStatic;
+    }
+
     get fileIndex() {
         return this.myFileIndex;
     }
@@ -190,6 +204,15 @@ class AppState {
             (item,i) => {item.reactCodeBlockChange()});
     }
 
+    notifyCodeBlockOutputReactors(fileIndex, index, event, data) {
+        if (fileIndex !== this.myFileIndex) {
+            // The block's file is no longer shown.
+            return;
+        }
+        this.codeBlockOutputReactors.forEach(
+            (item,i) => {item.reactCodeBlockOutput(index, event, data)});
+    }
+
     notifyCodeBlockRunReactors(index) {
         this.sessionController.save(this);
         this.codeBlockRunReactors.forEach(
@@ -204,6 +227,12 @@ class AppState {
         return this.file.CodeBlockLabels;
     }
 
+    // currRunnable says, for each code block in the current file,
+    // if the server will run it.
+    get currRunnable() {
+        return this.file.Runnable;
+    }
+
     get currCbRunCounts() {
         return this.file.CbRunCount;
     }
@@ -103,6 +103,57 @@ class CodeBlockController {
         addCheckMark(this.controlBar);
     }
 
+    // setExcluded marks (or unmarks) the block as one the block
+    // filter keeps from running.
+    setExcluded(excluded) {
+        this.el.classList.toggle('codeBlockExcluded', excluded);
+    }
+
+    // The output panel follows the codeBlock, rather than sitting in
+    // it, so that selecting output doesn't copy the code.
+    get outputPanel() {
+        let p = this.el.nextElementSibling;
+        if (p !== null && p.classList.contains('codeBlockOutput')) {
+            return p;
+        }
+        p = document.createElement('div');
+        p.className = 'codeBlockOutput';
+        this.el.after(p);
+        return p;
+    }
+
+    // showOutput shows an event from a run of the block, i.e. a 'start',
+    // a line of 'out' or 'err', the 'exit' status, or why the run
+    // 'failed' to finish.
+    showOutput(event, data) {
+        let p = this.outputPanel;
+        let line = document.createElement('div');
+        line.textContent = data;
+        switch (event) {
+            case 'start':
+                p.replaceChildren();
+                p.classList.remove('codeBlockOutputFailed');
+                return;
+            case 'out':
+                break;
+            case 'err':
+                line.className = 'codeBlockOutputErr';
+                break;
+            case 'exit':
+                line.className = 'codeBlockOutputStatus';
+                line.textContent = 'exit status ' + data;
+                if (data !== '0') {
+                    p.classList.add('codeBlockOutputFailed');
+                }
+                break;
+            default:
+                line.className = 'codeBlockOutputStatus';
+                p.classList.add('codeBlockOutputFailed');
+        }
+        p.appendChild(line);
+        p.scrollTop = p.scrollHeight;
+    }
+
     // ---------------------------------------------
     // The following involves copying code from the
     // codeBlock into the copy/paste buffer.
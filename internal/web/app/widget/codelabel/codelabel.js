@@ -26,6 +26,12 @@ class CodeLabelController {
         this.textArea.innerText = l;
     }
 
+    // setExcluded marks (or unmarks) the label's block as one the
+    // block filter keeps from running.
+    setExcluded(excluded) {
+        this.el.classList.toggle('codeLabelExcluded', excluded);
+    }
+
     onClick(f) {
         this.el.addEventListener('click', f);
     }
@@ -20,6 +20,16 @@ type ParamStructJsCss struct {
 	KeyBlockIndex  string
 	KeyIsTitleOn   string
 	KeyIsNavOn     string
+	KeyVarPrefix   string
+
+	// IsStatic is true if there's no server, e.g. in an exported
+	// site, so code blocks are copied rather than run.
+	IsStatic bool
+
+	// HasTerminal is true if the server offers a terminal
+	// that code blocks are typed into.
+	HasTerminal  bool
+	PathTerminal string
 
 	MdSessID          string
 	TransitionSpeedMs int
@@ -36,12 +46,14 @@ var (
 		PathGetHtmlForFile:   config.Dynamic(config.RouteHtmlForFile),
 		PathGetLabelsForFile: config.Dynamic(config.RouteLabelsForFile),
 		PathRunBlock:         config.Dynamic(config.RouteRunBlock),
+		PathTerminal:         config.Dynamic(config.RouteWebSocket),
 
 		KeyMdFileIndex: config.KeyMdFileIndex,
 		KeyBlockIndex:  config.KeyBlockIndex,
 		KeyIsTitleOn:   config.KeyIsTitleOn,
 		KeyIsNavOn:     config.KeyIsNavOn,
 		KeyMdSessID:    config.KeyMdSessID,
+		KeyVarPrefix:   config.KeyVarPrefix,
 
 		MdSessID:          "notARealSessId",
 		TransitionSpeedMs: 250,
@@ -15,6 +15,7 @@ class MdFilesController {
         appState.addFileChangeReactor(this);
         appState.addCodeBlockChangeReactor(this);
         appState.addCodeBlockRunReactor(this);
+        appState.addCodeBlockOutputReactor(this);
     }
 
     makeContentDiv() {
@@ -63,6 +64,10 @@ class MdFilesController {
             console.debug('No active code block.');
             return;
         }
+        if (this.appState.isStatic) {
+            // Nothing can run the block, so offer it for pasting.
+            this.cbControllers[this.cbIndex].attemptCopyToBuffer();
+        }
         this.appState.runCodeBlock()
     }
 
@@ -70,6 +75,10 @@ class MdFilesController {
         this.cbControllers[index].addCheckMark();
     }
 
+    reactCodeBlockOutput(index, event, data) {
+        this.cbControllers[index].showOutput(event, data);
+    }
+
     scrollToActiveCodeBlock() {
         if (this.appState.isGoodCurrCodeBlockIndex) {
             this.cbControllers[this.cbIndex].scrollIntoView()
@@ -80,8 +89,9 @@ class MdFilesController {
     //   The url changes when one hits the back/forward buttons,
     //   but the page content doesn't change.
     updateUrl() {
-        if (window.location.origin.startsWith("file://")) {
-            // This means one is debugging a local static rendering.
+        if (window.location.origin.startsWith("file://") ||
+            this.appState.isStatic) {
+            // A static rendering has no server to map paths to files.
             return;
         }
         let path = this.appState.currPath
@@ -101,6 +111,7 @@ class MdFilesController {
             cbc.addOnClick(()=>{
                 me.appState.setCodeBlockIndex(i);
             });
+            cbc.setExcluded(!this.appState.currRunnable[i]);
             let runCounts = this.appState.currCbRunCounts;
             for (let j = 0; j < runCounts[i]; j++) {
                 cbc.addCheckMark();
@@ -26,6 +26,7 @@ import (
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/navrightroot"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/navtop"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/terminal"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/timeline"
 )
 
@@ -69,6 +70,7 @@ var (
 			navcontentrow.Css,
 			navbottom.Css,
 			navrightroot.Css,
+			terminal.Css,
 			Css,
 		}, "\n")
 
@@ -92,6 +94,7 @@ var (
 			navrightroot.Js,
 			navbottom.Js,
 			monkey.Js,
+			terminal.Js,
 			Js,
 		}, "\n")
 )
@@ -128,12 +131,16 @@ type RenderingArgs struct {
 	DataSource string
 	Folder     *loader.MyFolder
 	Title      string
+	// Filter picks the blocks that may be run; nil means all.
+	Filter parsren.BlockFilter
 }
 
 // RenderFolder partially renders a folder, and computes an appState
 // which feeds into remaining rendering stages.
 func RenderFolder(rArgs *RenderingArgs) (
 	navLeftRoot template.HTML, appState *appstate.AppState) {
+	// Parse first, so that the nav sees the files' front matter.
+	loader.NewTopFolder(rArgs.Folder).Accept(rArgs.Pr)
 	numFolders := 0
 	maxFileNameLen := 0
 	{
@@ -145,9 +152,9 @@ func RenderFolder(rArgs *RenderingArgs) (
 		maxFileNameLen = v.MaxFileNameLength()
 	}
 	{
-		loader.NewTopFolder(rArgs.Folder).Accept(rArgs.Pr)
 		appState = appstate.New(
-			rArgs.DataSource, rArgs.Pr.RenderedMdFiles(), rArgs.Title)
+			rArgs.DataSource, rArgs.Pr.RenderedMdFiles(), rArgs.Title,
+			rArgs.Filter)
 		maxLabelLen := 0
 		for _, b := range rArgs.Pr.Filter(
 			func(b *loader.CodeBlock) bool { return true }) {
@@ -61,6 +61,7 @@ class MdRipController {
         let nlc = new NavLeftRootController(as);
         let nrc = new NavRightRootController(as);
         this.mkc = new MonkeyController(as, this.hbc);
+        this.tc = ('{{.HasTerminal}}' === 'true') ? new TerminalController() : null;
         this.wireUpHandlers();
     }
 
@@ -82,6 +83,12 @@ class MdRipController {
                 case '!':
                     nac.mkc.toggle();
                     break;
+                case 't':
+                    if (nac.tc !== null) {
+                        event.preventDefault();
+                        nac.tc.toggle();
+                    }
+                    break;
                 case '-':
                     nac.appState.toggleTitle();
                     break;
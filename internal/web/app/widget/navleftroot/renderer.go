@@ -82,7 +82,8 @@ func (v *Renderer) VisitFile(x *loader.MyFile) {
 	atp := baseAtp
 	atp.ObjectId = v.indexFile
 	atp.FilePath = v.path()
-	atp.FileName = strings.TrimSuffix(x.Name(), ".md")
+	// The title from the file's front matter, if any, reads better.
+	atp.FileName = strings.TrimSuffix(x.Title(), ".md")
 
 	{
 		length := (v.depth * indentPerDepth) + len(atp.FileName)
@@ -49,6 +49,7 @@ class NavRightRootController {
             let c = this.labelController[i];
             c.deActivate();
             c.setLabel(this.appState.currCodeBlocks[i]);
+            c.setExcluded(!this.appState.currRunnable[i]);
             c.removeAllCheckMarks();
             let runCounts = this.appState.currCbRunCounts;
             for (let j = 0; j < runCounts[i]; j++) {
@@ -59,6 +60,7 @@ class NavRightRootController {
             let c = this.labelController[i];
             c.deActivate();
             c.setLabel("");
+            c.setExcluded(false);
             c.removeAllCheckMarks();
         }
     }
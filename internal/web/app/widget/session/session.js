@@ -4,9 +4,11 @@ class SessionController {
         this.enabled = false;
         this.isCodeRunning = false;
         this.isSessionSavingEnabled = false;
+        // isStatic is true if there's no server to talk to.
+        this.isStatic = ('{{.IsStatic}}' === 'true');
         // rfCache is a local cache of rendered files.
-        // rfCache should be []HtmlAndLabels, i.e.
-        // an array of { Html string, CodeBlockLabels, []labels CbRunCount[]int }.
+        // rfCache should be []HtmlAndLabels, i.e. an array of
+        // { Html string, CodeBlockLabels []labels, Runnable []bool, CbRunCount []int }.
         this.rfCache = rf;
     }
 
@@ -22,6 +24,7 @@ class SessionController {
         let ans = {
             Html: "<p> Bad file index! </p>",
             CodeBlockLabels: ["ohNo"],
+            Runnable: [false],
             CbRunCount: [0],
         }
         if (fileIndex < 0 || fileIndex >= this.rfCache.length) {
@@ -47,17 +50,19 @@ class SessionController {
                 return r.json();
             })
             .then((r) => {
-                ans.CodeBlockLabels = r;
-                ans.CbRunCount = new Array(r.length)
-                for (let i = 0; i < r.length; i++) {
-                    ans.CbRunCount[i] = 0;
-                }
+                ans.CodeBlockLabels = r.CodeBlockNames || [];
+                ans.Runnable = r.Runnable || [];
+                ans.CbRunCount = new Array(ans.CodeBlockLabels.length).fill(0);
                 this.rfCache[fileIndex] = ans;
                 doneClosure(this.rfCache[fileIndex]);
             })
     }
 
     reload(doneClosure) {
+        if (this.isStatic) {
+            console.debug('static rendering; nothing to reload');
+            return;
+        }
         console.debug('Session calling server to reaload all data');
         fetch('{{.PathReload}}', {
             // See nearby note regarding POST.
@@ -100,11 +105,23 @@ class SessionController {
         })
     }
 
-    runBlock(fileIndex, codeBlockIndex, doneClosure) {
+    // runBlock asks the server to run the block.  If the server
+    // says the block has placeholders without values, it asks the
+    // user for the values, then tries again with them.
+    // If the server runs the block itself, it replies with events
+    // holding the block's output, which go to outputClosure as
+    // (name, data), preceded by a 'start' event.
+    runBlock(fileIndex, codeBlockIndex, doneClosure, outputClosure, values = {}) {
         if (!this.enabled) {
             console.debug("session disabled; not running block")
             return;
         }
+        if (this.isStatic) {
+            // The block was copied to the clipboard instead.
+            this.recordRunBlock(fileIndex, codeBlockIndex);
+            doneClosure();
+            return;
+        }
         if (this.isCodeRunning) {
             alert('busy!');
             return;
@@ -115,16 +132,131 @@ class SessionController {
             + '?{{.KeyMdFileIndex}}=' + fileIndex
             + '&{{.KeyBlockIndex}}=' + codeBlockIndex
             + '&{{.KeyMdSessID}}={{.MdSessID}}';
+        for (const [name, value] of Object.entries(values)) {
+            url += '&{{.KeyVarPrefix}}' + encodeURIComponent(name)
+                + '=' + encodeURIComponent(value);
+        }
         fetch(url, {
             // See nearby note regarding POST.
             method: "POST",
         }).then((r) => {
+            if (r.status === 409) {
+                me.isCodeRunning = false;
+                return r.json().then((names) => {
+                    me.askForValues(names, (v) => {
+                        if (v === null) {
+                            outputClosure('start', '');
+                            outputClosure('failed', 'cancelled; no value for '
+                                + names.join(', '));
+                            return;
+                        }
+                        me.runBlock(
+                            fileIndex, codeBlockIndex, doneClosure, outputClosure, v);
+                    });
+                });
+            }
+            if (!r.ok) {
+                // The block wasn't run, e.g. it's excluded by the
+                // block filter, or the runner couldn't take it; say why.
+                return r.text().then((msg) => {
+                    me.isCodeRunning = false;
+                    outputClosure('start', '');
+                    outputClosure('failed', msg.trim());
+                });
+            }
+            let type = r.headers.get('Content-Type') || '';
+            if (type.startsWith('text/event-stream')) {
+                outputClosure('start', '');
+                return me.readEvents(r, outputClosure).then(() => {
+                    me.isCodeRunning = false;
+                    me.recordRunBlock(fileIndex, codeBlockIndex);
+                    doneClosure();
+                });
+            }
             me.isCodeRunning = false;
             this.recordRunBlock(fileIndex, codeBlockIndex);
             doneClosure();
+        }).catch((err) => {
+            me.isCodeRunning = false;
+            console.error('unable to run block', err);
         })
     }
 
+    // readEvents passes each server-sent event in the response to
+    // eventClosure as (name, data), and returns a promise that's
+    // resolved when the response ends.  EventSource can't be used,
+    // since it only does GET.
+    readEvents(r, eventClosure) {
+        let reader = r.body.pipeThrough(new TextDecoderStream()).getReader();
+        let buf = '';
+        let pump = () => reader.read().then(({done, value}) => {
+            if (done) {
+                return;
+            }
+            buf += value;
+            let events = buf.split('\n\n');
+            buf = events.pop();
+            events.forEach((e) => {
+                let name = 'message';
+                let data = [];
+                e.split('\n').forEach((l) => {
+                    if (l.startsWith('event: ')) {
+                        name = l.slice(7);
+                    } else if (l.startsWith('data: ')) {
+                        data.push(l.slice(6));
+                    }
+                });
+                eventClosure(name, data.join('\n'));
+            });
+            return pump();
+        });
+        return pump();
+    }
+
+    // askForValues shows a small form asking for the values of the
+    // named variables, and passes them to doneClosure, or passes
+    // null if the user cancels, e.g. with Esc.
+    askForValues(names, doneClosure) {
+        let dialog = document.createElement('dialog');
+        let form = document.createElement('form');
+        form.method = 'dialog';
+        let inputs = names.map((name) => {
+            let label = document.createElement('label');
+            label.textContent = name + ' ';
+            let input = document.createElement('input');
+            input.name = name;
+            input.required = true;
+            label.appendChild(input);
+            let p = document.createElement('p');
+            p.appendChild(label);
+            form.appendChild(p);
+            return input;
+        });
+        let run = document.createElement('button');
+        run.textContent = 'Run';
+        run.value = 'run';
+        let cancel = document.createElement('button');
+        cancel.textContent = 'Cancel';
+        cancel.value = 'cancel';
+        cancel.formNoValidate = true;
+        form.append(run, ' ', cancel);
+        dialog.appendChild(form);
+        // Keep typing in the form away from the app's key handlers.
+        dialog.addEventListener('keydown', (e) => {e.stopPropagation()});
+        dialog.addEventListener('close', () => {
+            document.body.removeChild(dialog);
+            if (dialog.returnValue !== 'run') {
+                doneClosure(null);
+                return;
+            }
+            let values = {};
+            inputs.forEach((i) => {values[i.name] = i.value;});
+            doneClosure(values);
+        });
+        document.body.appendChild(dialog);
+        dialog.showModal();
+    }
+
     recordRunBlock(fileIndex, codeBlockIndex) {
         let f = this.rfCache[fileIndex];
         if (f === null) {
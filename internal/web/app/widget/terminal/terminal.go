@@ -0,0 +1,11 @@
+package terminal
+
+import _ "embed"
+
+var (
+	//go:embed terminal.js
+	Js string
+
+	//go:embed terminal.css
+	Css string
+)
@@ -0,0 +1,234 @@
+// TerminalController shows the server's terminal in a pane
+// along the bottom of the window.
+//
+// Code blocks run by the server are typed into the terminal, and
+// with the pane focused, keystrokes are typed into it too.
+//
+// This isn't a full terminal emulator; it understands just enough
+// escape sequences to follow a shell prompt and line editing.
+// The shell is started with TERM=dumb, so programs shouldn't
+// expect more.
+class TerminalController {
+    constructor() {
+        this.pane = document.createElement('pre');
+        this.pane.classList.add('terminalPane');
+        this.pane.tabIndex = 0;
+        document.body.appendChild(this.pane);
+        this.lines = [''];
+        this.row = 0;
+        this.col = 0;
+        this.pending = '';
+        this.decoder = new TextDecoder();
+        this.socket = null;
+        this.pane.addEventListener('keydown', (e) => this.onKey(e), false);
+        this.pane.addEventListener('paste', (e) => {
+            e.preventDefault();
+            this.send({input: e.clipboardData.getData('text')});
+        }, false);
+        window.addEventListener('resize', () => this.sendSize(), false);
+    }
+
+    get isOn() {
+        return this.pane.classList.contains('terminalPaneOn');
+    }
+
+    toggle() {
+        if (this.isOn) {
+            this.pane.classList.remove('terminalPaneOn');
+            this.pane.blur();
+            return;
+        }
+        this.pane.classList.add('terminalPaneOn');
+        this.pane.focus();
+        this.connect();
+    }
+
+    connect() {
+        if (this.socket !== null) {
+            return;
+        }
+        const scheme = (window.location.protocol === 'https:') ? 'wss' : 'ws';
+        this.socket = new WebSocket(
+            scheme + '://' + window.location.host + '{{.PathTerminal}}');
+        this.socket.binaryType = 'arraybuffer';
+        this.socket.onopen = () => this.sendSize();
+        this.socket.onmessage = (e) => {
+            this.write(this.decoder.decode(
+                new Uint8Array(e.data), {stream: true}));
+        };
+        this.socket.onclose = () => {
+            this.socket = null;
+            this.write('\r\n[disconnected; press t twice to reconnect]\r\n');
+        };
+    }
+
+    send(msg) {
+        if (this.socket !== null && this.socket.readyState === WebSocket.OPEN) {
+            this.socket.send(JSON.stringify(msg));
+        }
+    }
+
+    // sendSize estimates how many characters fit in the pane.
+    sendSize() {
+        const probe = document.createElement('span');
+        probe.textContent = 'X';
+        this.pane.appendChild(probe);
+        const w = probe.getBoundingClientRect().width || 8;
+        const h = probe.getBoundingClientRect().height || 16;
+        this.pane.removeChild(probe);
+        this.send({
+            rows: Math.max(2, Math.floor(this.pane.clientHeight / h)),
+            cols: Math.max(20, Math.floor(this.pane.clientWidth / w) - 2),
+        });
+    }
+
+    onKey(e) {
+        const keys = {
+            'Enter': '\r',
+            'Backspace': '\x7f',
+            'Tab': '\t',
+            'Escape': '\x1b',
+            'ArrowUp': '\x1b[A',
+            'ArrowDown': '\x1b[B',
+            'ArrowRight': '\x1b[C',
+            'ArrowLeft': '\x1b[D',
+            'Home': '\x1b[H',
+            'End': '\x1b[F',
+            'Delete': '\x1b[3~',
+        };
+        let s = keys[e.key];
+        if (e.ctrlKey && e.key.length === 1) {
+            const c = e.key.toLowerCase().charCodeAt(0);
+            if (c >= 97 && c <= 122) {
+                s = String.fromCharCode(c - 96);
+            } else if (e.key === 'v') {
+                return; // let paste happen
+            }
+        } else if (s === undefined && e.key.length === 1 && !e.metaKey) {
+            s = e.key;
+        }
+        // Keep the app's own key bindings out of the terminal.
+        e.stopPropagation();
+        if (s !== undefined) {
+            e.preventDefault();
+            this.send({input: s});
+        }
+    }
+
+    // write interprets terminal output.
+    write(text) {
+        text = this.pending + text;
+        this.pending = '';
+        let i = 0;
+        while (i < text.length) {
+            const c = text[i];
+            if (c === '\x1b') {
+                const n = this.escape(text, i);
+                if (n < 0) {
+                    this.pending = text.substring(i);
+                    break;
+                }
+                i = n;
+                continue;
+            }
+            if (c === '\r') {
+                this.col = 0;
+            } else if (c === '\n') {
+                this.row++;
+                if (this.row === this.lines.length) {
+                    this.lines.push('');
+                }
+            } else if (c === '\b') {
+                this.col = Math.max(0, this.col - 1);
+            } else if (c === '\x07') {
+                // bell
+            } else {
+                this.put(c);
+            }
+            i++;
+        }
+        const max = 2000;
+        if (this.lines.length > max) {
+            const drop = this.lines.length - max;
+            this.lines.splice(0, drop);
+            this.row = Math.max(0, this.row - drop);
+        }
+        this.pane.textContent = this.lines.join('\n');
+        this.pane.scrollTop = this.pane.scrollHeight;
+    }
+
+    put(c) {
+        const line = this.lines[this.row].padEnd(this.col);
+        this.lines[this.row] =
+            line.substring(0, this.col) + c + line.substring(this.col + 1);
+        this.col++;
+    }
+
+    // escape handles the escape sequence starting at text[i], and
+    // returns the index following it, or -1 if the sequence is
+    // incomplete.
+    escape(text, i) {
+        if (i + 1 >= text.length) {
+            return -1;
+        }
+        const kind = text[i + 1];
+        if (kind === ']') {
+            // OSC, e.g. a window title; ends with BEL or ST.
+            for (let j = i + 2; j < text.length; j++) {
+                if (text[j] === '\x07') {
+                    return j + 1;
+                }
+                if (text[j] === '\x1b' && j + 1 < text.length) {
+                    return j + 2;
+                }
+            }
+            return -1;
+        }
+        if (kind !== '[') {
+            return i + 2;
+        }
+        let j = i + 2;
+        while (j < text.length && !/[@-~]/.test(text[j])) {
+            j++;
+        }
+        if (j >= text.length) {
+            return -1;
+        }
+        const args = text.substring(i + 2, j);
+        const n = parseInt(args, 10) || 0;
+        const line = this.lines[this.row];
+        switch (text[j]) {
+            case 'K':
+                if (n === 0) {
+                    this.lines[this.row] = line.substring(0, this.col);
+                } else if (n === 2) {
+                    this.lines[this.row] = '';
+                }
+                break;
+            case 'C':
+                this.col += Math.max(1, n);
+                break;
+            case 'D':
+                this.col = Math.max(0, this.col - Math.max(1, n));
+                break;
+            case 'P':
+                this.lines[this.row] = line.substring(0, this.col) +
+                    line.substring(this.col + Math.max(1, n));
+                break;
+            case 'H':
+            case 'J':
+                if (text[j] === 'J' && n < 2) {
+                    this.lines[this.row] = line.substring(0, this.col);
+                    this.lines.length = this.row + 1;
+                } else {
+                    this.lines = [''];
+                    this.row = 0;
+                    this.col = 0;
+                }
+                break;
+            default:
+                // colors and the like are ignored.
+        }
+        return j + 1;
+    }
+}
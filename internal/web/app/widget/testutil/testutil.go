@@ -135,7 +135,7 @@ func makeAppStateTest2(
 	dSource string, folder loader.MyTreeNode, title string) *appstate.AppState {
 	v := usegold.NewGParser()
 	folder.Accept(v)
-	return appstate.New(dSource, v.RenderedMdFiles(), title)
+	return appstate.New(dSource, v.RenderedMdFiles(), title, nil)
 }
 
 func MakeFolderTreeOfMarkdown() *loader.MyFolder {
@@ -34,8 +34,8 @@ const (
 	RouteQuit // quit
 	// RouteDebug tells the server to render a debug page.
 	RouteDebug // debug
-	// RouteWebSocket sets up a socket.
-	RouteWebSocket // debug
+	// RouteWebSocket sets up a socket, e.g. to a terminal.
+	RouteWebSocket // ws
 )
 
 func Dynamic(r Route) string {
@@ -56,4 +56,7 @@ const (
 	KeyMdFileIndex = "fix"
 	// KeyBlockIndex is the param name for the code block index.
 	KeyBlockIndex = "bix"
+	// KeyVarPrefix prefixes the param names of variable values,
+	// e.g. "var.PROJECT_ID".
+	KeyVarPrefix = "var."
 )
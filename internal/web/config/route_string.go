@@ -22,9 +22,9 @@ func _() {
 	_ = x[RouteWebSocket-11]
 }
 
-const _Route_name = "RouteUnknownjscssreloadlabelsForFilehtmlForFilerunCodeBlocksaveimagequitdebugdebug"
+const _Route_name = "RouteUnknownjscssreloadlabelsForFilehtmlForFilerunCodeBlocksaveimagequitdebugws"
 
-var _Route_index = [...]uint8{0, 12, 14, 17, 23, 36, 47, 59, 63, 68, 72, 77, 82}
+var _Route_index = [...]uint8{0, 12, 14, 17, 23, 36, 47, 59, 63, 68, 72, 77, 79}
 
 func (i Route) String() string {
 	if i < 0 || i >= Route(len(_Route_index)-1) {
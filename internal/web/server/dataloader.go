@@ -19,6 +19,7 @@ import (
 type DataLoader struct {
 	ldr         *loader.FsLoader
 	pRen        parsren.MdParserRenderer
+	filter      parsren.BlockFilter
 	paths       []string
 	title       string
 	folder      *loader.MyFolder
@@ -31,11 +32,13 @@ const maxAge = 30 * time.Second
 
 func NewDataLoader(
 	ldr *loader.FsLoader, paths []string,
-	pRen parsren.MdParserRenderer, title string) *DataLoader {
+	pRen parsren.MdParserRenderer, title string,
+	filter parsren.BlockFilter) *DataLoader {
 	return &DataLoader{
 		ldr:      ldr,
 		paths:    paths,
 		pRen:     pRen,
+		filter:   filter,
 		title:    title,
 		folder:   nil,
 		loadTime: time.Time{},
@@ -54,6 +57,12 @@ func (dl *DataLoader) AllBlocks() []*loader.CodeBlock {
 	return dl.pRen.Filter(func(b *loader.CodeBlock) bool { return true })
 }
 
+// IsRunnable is true if the block passes the loader's filter,
+// i.e. if it may be sent to the code writer.
+func (dl *DataLoader) IsRunnable(b *loader.CodeBlock) bool {
+	return dl.filter == nil || dl.filter(b)
+}
+
 func (dl *DataLoader) LoadAndRender() (err error) {
 	if len(dl.paths) == 0 {
 		return fmt.Errorf("specify some paths to load")
@@ -88,6 +97,7 @@ func (dl *DataLoader) LoadAndRender() (err error) {
 			DataSource: dl.getDataSource(),
 			Folder:     dl.folder,
 			Title:      dl.title,
+			Filter:     dl.filter,
 		},
 	)
 	return
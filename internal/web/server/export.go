@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	htmlTmpl "html/template"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/web/app"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/appstate"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/mdrip"
+)
+
+// ExportFileName is the name of the page written by Export.
+const ExportFileName = "index.html"
+
+// imgSrcRe matches the src attribute of an img element.
+var imgSrcRe = regexp.MustCompile(`(<img\s[^>]*?src=")([^"]+)(")`)
+
+// Export writes the web app, with every markdown file rendered into
+// it, as one HTML page in outDir that needs no server.  Code blocks
+// are copied to the clipboard rather than run.  Images referenced
+// by relative paths are copied into outDir, keeping their paths
+// relative to the loaded folder.  It returns the paths written.
+func (dl *DataLoader) Export(outDir string) ([]string, error) {
+	if err := dl.LoadAndRender(); err != nil {
+		return nil, err
+	}
+	root := dl.paths[0]
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		root = filepath.Dir(root)
+	}
+	as := *dl.appState
+	as.Facts.InitialFileIndex = 0
+	as.RenderedFiles = make([]appstate.HtmlAndLabels, len(dl.appState.RenderedFiles))
+	images := make(map[string]string)
+	for i, f := range dl.appState.RenderedFiles {
+		as.RenderedFiles[i] = appstate.HtmlAndLabels{
+			Html: htmlTmpl.HTML(relocateImages(
+				string(f.Html), string(as.OrderedPaths[i]), root, images)),
+			BlockLabels: f.BlockLabels,
+		}
+	}
+	params := mdrip.MakeParams(dl.navLeftRoot, &as)
+	params.IsStatic = true
+	js, err := inflate(mdrip.AsTmplJs(), mdrip.TmplNameJs, params)
+	if err != nil {
+		return nil, err
+	}
+	css, err := inflate(mdrip.AsTmplCss(), mdrip.TmplNameCss, params)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := common.ParseAsHtmlTemplate(app.AsStaticTmpl())
+	if err != nil {
+		return nil, fmt.Errorf("template parsing fail; %w", err)
+	}
+	var b bytes.Buffer
+	if err = tmpl.ExecuteTemplate(&b, app.TmplName, &app.StaticParams{
+		TmplParams: params,
+		Css:        htmlTmpl.CSS(css),
+		Js:         htmlTmpl.JS(js),
+	}); err != nil {
+		return nil, fmt.Errorf("template rendering failure; %w", err)
+	}
+	if err = os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, err
+	}
+	page := filepath.Join(outDir, ExportFileName)
+	if err = os.WriteFile(page, b.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	written := []string{page}
+	for _, src := range slices.Sorted(maps.Keys(images)) {
+		dst := filepath.Join(outDir, filepath.FromSlash(images[src]))
+		if err = copyFile(src, dst); err != nil {
+			return written, err
+		}
+		written = append(written, dst)
+	}
+	return written, nil
+}
+
+// inflate executes a template of JS or CSS.  These are parsed as
+// text, since parsing them as HTML would escape them.
+func inflate(body, name string, params any) (string, error) {
+	tmpl, err := common.ParseAsTextTemplate(body)
+	if err != nil {
+		return "", fmt.Errorf("%s parse fail; %w", name, err)
+	}
+	var b bytes.Buffer
+	if err = tmpl.ExecuteTemplate(&b, name, params); err != nil {
+		return "", fmt.Errorf("failed to inflate %s; %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// relocateImages rewrites the relative image paths in the content of
+// the markdown file at mdPath, itself relative to the folder root, to
+// be relative to root, where the exported page lives.  It records, in
+// images, the path in the export of each image file to copy.  Images
+// outside root, or missing, are left be.
+func relocateImages(content, mdPath, root string, images map[string]string) string {
+	return imgSrcRe.ReplaceAllStringFunc(content, func(m string) string {
+		sub := imgSrcRe.FindStringSubmatch(m)
+		src := html.UnescapeString(sub[2])
+		u, err := url.Parse(src)
+		if err != nil || u.IsAbs() || u.Host != "" ||
+			strings.HasPrefix(u.Path, "/") || u.Path == "" {
+			return m
+		}
+		rel := path.Join(path.Dir(mdPath), u.Path)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			slog.Warn("image outside exported folder", "src", src, "file", mdPath)
+			return m
+		}
+		file := filepath.Join(root, filepath.FromSlash(rel))
+		if _, err = os.Stat(file); err != nil {
+			slog.Warn("image not found", "src", src, "file", mdPath)
+			return m
+		}
+		images[file] = rel
+		u.Path = rel
+		return sub[1] + htmlTmpl.HTMLEscapeString(u.String()) + sub[3]
+	})
+}
+
+func copyFile(src, dst string) error {
+	c, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, c, 0o644)
+}
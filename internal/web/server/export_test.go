@@ -0,0 +1,162 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren/usegold"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTree writes files, given by slash-separated paths relative to
+// a new temporary folder, and returns the folder.
+func writeTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		f := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(f), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRelocateImages(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"top.png":          "top",
+		"docs/pic one.png": "pic",
+		"docs/a&b.png":     "ab",
+		"img/logo.png":     "logo",
+	})
+	type testC struct {
+		src    string
+		want   string
+		copied string
+	}
+	for name, tc := range map[string]testC{
+		"missing": {
+			src:    `logo.png`,
+			want:   `logo.png`,
+			copied: "",
+		},
+		"escapedSpace": {
+			src:    `pic%20one.png`,
+			want:   `docs/pic%20one.png`,
+			copied: "docs/pic one.png",
+		},
+		"escapedAmpersand": {
+			src:    `a&amp;b.png`,
+			want:   `docs/a&amp;b.png`,
+			copied: "docs/a&b.png",
+		},
+		"upToRoot": {
+			src:    `../img/logo.png`,
+			want:   `img/logo.png`,
+			copied: "img/logo.png",
+		},
+		"upAndDown": {
+			src:    `../docs/../top.png`,
+			want:   `top.png`,
+			copied: "top.png",
+		},
+		"outsideRoot": {
+			src:    `../../secret.png`,
+			want:   `../../secret.png`,
+			copied: "",
+		},
+		"queryString": {
+			src:    `pic%20one.png?raw=true&amp;v=2`,
+			want:   `docs/pic%20one.png?raw=true&amp;v=2`,
+			copied: "docs/pic one.png",
+		},
+		"absoluteUrl": {
+			src:    `https://example.com/docs/pic.png`,
+			want:   `https://example.com/docs/pic.png`,
+			copied: "",
+		},
+		"absolutePath": {
+			src:    `/img/logo.png`,
+			want:   `/img/logo.png`,
+			copied: "",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			images := make(map[string]string)
+			content := `<p><img src="` + tc.src + `" alt="x"></p>`
+			got := relocateImages(content, "docs/page.md", root, images)
+			assert.Equal(t, `<p><img src="`+tc.want+`" alt="x"></p>`, got)
+			if tc.copied == "" {
+				assert.Empty(t, images)
+				return
+			}
+			assert.Equal(t, map[string]string{
+				filepath.Join(root, filepath.FromSlash(tc.copied)): tc.copied,
+			}, images)
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	in := writeTree(t, map[string]string{
+		"README.md": `# Greetings
+
+![logo](img/logo.png)
+
+` + "```" + `
+echo hello
+` + "```" + `
+`,
+		"docs/more.md": `# More
+
+![pic](pic%20one.png) ![gone](missing.png)
+`,
+		"img/logo.png":     "logo",
+		"docs/pic one.png": "pic",
+		"img/unused.png":   "unused",
+	})
+	out := filepath.Join(t.TempDir(), "site")
+	dl := NewDataLoader(
+		loader.New(afero.NewOsFs(), loader.IsMarkDownFile, loader.InNotIgnorableFolder),
+		[]string{in}, usegold.NewGParser(), "myTitle", nil)
+
+	written, err := dl.Export(out)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{
+		filepath.Join(out, ExportFileName),
+		filepath.Join(out, "docs", "pic one.png"),
+		filepath.Join(out, "img", "logo.png"),
+	}, written)
+	for _, f := range []string{"docs/pic one.png", "img/logo.png"} {
+		c, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(f)))
+		assert.NoError(t, err)
+		want, _ := os.ReadFile(filepath.Join(in, filepath.FromSlash(f)))
+		assert.Equal(t, want, c)
+	}
+	assert.NoFileExists(t, filepath.Join(out, "img", "unused.png"))
+
+	c, err := os.ReadFile(filepath.Join(out, ExportFileName))
+	if !assert.NoError(t, err) {
+		return
+	}
+	page := string(c)
+	assert.Contains(t, page, "<title>myTitle</title>")
+	// The cache holds every rendered file, so no server is asked.
+	assert.Contains(t, page, "return [{")
+	assert.Contains(t, page, "Greetings")
+	assert.Contains(t, page, "echo hello")
+	assert.Contains(t, page, "docs/pic%20one.png")
+	assert.Contains(t, page, "img/logo.png")
+	assert.Contains(t, page, "missing.png")
+	assert.NotContains(t, page, "/_/js")
+	assert.NotContains(t, page, "/_/css")
+	assert.Equal(t, 1, strings.Count(page, "<style>"))
+}
@@ -4,13 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	htmlTmpl "html/template"
+	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/web/app"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/appstate"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/mdrip"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
@@ -90,7 +96,8 @@ func (ws *Server) handleGetLabelsForFile(wr http.ResponseWriter, req *http.Reque
 		return
 	}
 	var jsn []byte
-	jsn, err = json.Marshal(loader.NewBlockNameList(f.Blocks))
+	jsn, err = json.Marshal(
+		appstate.NewBlockLabels(f.Blocks, ws.dLoader.IsRunnable))
 	if err != nil {
 		write500(wr, fmt.Errorf("handleGetLabelsForFile marshal; %w", err))
 		return
@@ -107,10 +114,9 @@ func (ws *Server) handleGetJs(wr http.ResponseWriter, req *http.Request) {
 	ws.minifier.Write(wr, &minify.Args{
 		MimeType: app.MimeJs,
 		Tmpl: minify.TmplArgs{
-			Name: mdrip.TmplNameJs,
-			Body: mdrip.AsTmplJs(),
-			Params: mdrip.MakeBaseParams(
-				ws.dLoader.appState.Facts.MaxNavWordLength),
+			Name:   mdrip.TmplNameJs,
+			Body:   mdrip.AsTmplJs(),
+			Params: ws.makeBaseParams(),
 		},
 	})
 }
@@ -120,13 +126,20 @@ func (ws *Server) handleGetCss(wr http.ResponseWriter, req *http.Request) {
 	ws.minifier.Write(wr, &minify.Args{
 		MimeType: app.MimeCss,
 		Tmpl: minify.TmplArgs{
-			Name: mdrip.TmplNameCss,
-			Body: mdrip.AsTmplCss(),
-			Params: mdrip.MakeBaseParams(
-				ws.dLoader.appState.Facts.MaxNavWordLength),
+			Name:   mdrip.TmplNameCss,
+			Body:   mdrip.AsTmplCss(),
+			Params: ws.makeBaseParams(),
 		},
 	})
 }
+
+// makeBaseParams returns the parameters of the web app's JS and CSS.
+func (ws *Server) makeBaseParams() *mdrip.TmplParams {
+	p := mdrip.MakeBaseParams(ws.dLoader.appState.Facts.MaxNavWordLength)
+	p.HasTerminal = ws.hasTerminal()
+	return p
+}
+
 func (ws *Server) handleFavicon(w http.ResponseWriter, _ *http.Request) {
 	Lissajous(w, 7, 3, 1)
 }
@@ -163,6 +176,9 @@ func (ws *Server) handleDebugPage(wr http.ResponseWriter, req *http.Request) {
 func (ws *Server) handleQuit(w http.ResponseWriter, _ *http.Request) {
 	slog.Debug("Received quit.")
 	_, _ = fmt.Fprint(w, "\nbye bye\n")
+	if s, ok := ws.codeWriter.(interface{ Stop() }); ok {
+		s.Stop()
+	}
 	go func() {
 		time.Sleep(2 * time.Second)
 		os.Exit(0)
@@ -172,6 +188,15 @@ func (ws *Server) handleQuit(w http.ResponseWriter, _ *http.Request) {
 func (ws *Server) handleRunCodeBlock(wr http.ResponseWriter, req *http.Request) {
 	slog.Debug(" ")
 	slog.Debug("Running code block", "url", req.URL)
+	if req.Method != http.MethodPost {
+		http.Error(wr, "use POST to run a block", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := sameOrigin(req); err != nil {
+		// Else any page in the browser could run blocks.
+		http.Error(wr, err.Error(), http.StatusForbidden)
+		return
+	}
 	arg := req.URL.Query().Get(config.KeyMdSessID)
 	if len(arg) == 0 {
 		http.Error(wr, "No session id for block codeWriter", http.StatusBadRequest)
@@ -197,9 +222,174 @@ func (ws *Server) handleRunCodeBlock(wr http.ResponseWriter, req *http.Request)
 		return
 	}
 	block := mdFile.Blocks[blockIndex]
+	if !ws.dLoader.IsRunnable(block) {
+		slog.Debug("block excluded by filter", "block", block.UniqName())
+		http.Error(wr, "block excluded by --label or --lang", http.StatusForbidden)
+		return
+	}
 
-	if _, err := ws.codeWriter.Write([]byte(block.Code())); err != nil {
+	block, unresolved, err := ws.withVars(block, req)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(unresolved) > 0 {
+		// The web app asks for the values, and tries again.
+		slog.Debug("block has unresolved placeholders", "names", unresolved)
+		wr.Header().Set("Content-Type", "application/json")
+		wr.WriteHeader(http.StatusConflict)
+		if err := json.NewEncoder(wr).Encode(unresolved); err != nil {
+			slog.Error("unable to write placeholders", "err", err)
+		}
+		return
+	}
+
+	if sr, ok := ws.codeWriter.(StreamingRunner); ok {
+		ws.streamCodeBlock(wr, req, sr, block)
+		return
+	}
+
+	// Only the dir, env and session params make sense when writing to a
+	// terminal; timeouts and retries are left to the human.
+	if _, err := ws.writerFor(block).Write([]byte(block.Script())); err != nil {
 		slog.Error("codeWriter failed", "err", err)
+		http.Error(wr, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
 	_, _ = fmt.Fprintln(wr, "Ok")
 }
+
+// Names of the server-sent events reporting the run of a block.
+const (
+	eventOut    = "out"
+	eventErr    = "err"
+	eventExit   = "exit"
+	eventFailed = "failed"
+)
+
+// streamCodeBlock runs the block in the shell of the browser session,
+// and the block's session, if any, replying with server-sent events
+// holding lines of stdout and stderr as they arrive, then the block's
+// exit status, or why it has none.
+func (ws *Server) streamCodeBlock(
+	wr http.ResponseWriter, req *http.Request,
+	sr StreamingRunner, block *loader.CodeBlock) {
+	key := string(ws.browserSession(req))
+	if s := block.Session(); s != "" {
+		key += "/" + s
+	}
+	flusher, _ := wr.(http.Flusher)
+	wr.Header().Set("Content-Type", mimeEventStream)
+	wr.Header().Set("Cache-Control", "no-cache")
+	send := func(event, data string) {
+		_, _ = fmt.Fprintf(wr, "event: %s\n", event)
+		// The browser joins data fields with newlines.
+		for _, l := range strings.Split(lineEnds.Replace(data), "\n") {
+			_, _ = fmt.Fprintf(wr, "data: %s\n", l)
+		}
+		_, _ = fmt.Fprint(wr, "\n")
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	status, err := sr.RunBlock(key, block, func(isErr bool, line string) {
+		if isErr {
+			send(eventErr, line)
+		} else {
+			send(eventOut, line)
+		}
+	})
+	if err != nil {
+		slog.Debug("block failed to finish", "block", block.UniqName(), "err", err)
+		send(eventFailed, err.Error())
+		return
+	}
+	send(eventExit, strconv.Itoa(status))
+}
+
+// mimeEventStream is the content type of server-sent events.
+const mimeEventStream = "text/event-stream"
+
+// lineEnds normalizes line ends, since a carriage return alone
+// ends a line in an event stream.
+var lineEnds = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// browserSession returns the id of the browser session in the
+// request's cookie.
+func (ws *Server) browserSession(req *http.Request) session.TypeSessID {
+	mySess, _ := ws.store.Get(req, cookieName)
+	session.AssureDefaults(mySess)
+	return session.ConvertToBucket(mySess).MdSessID
+}
+
+// safeValueRe matches variable values that can be pasted into code
+// without changing its meaning, i.e. values with no characters
+// special to the shell.
+var safeValueRe = regexp.MustCompile(`^[A-Za-z0-9_./:@%+=,-]+$`)
+
+// withVars returns the block with its placeholders replaced, after
+// remembering any variable values sent with the request, so that
+// they needn't be entered again for later blocks run by the same
+// browser session.  Values holding characters special to the shell
+// are refused, since they're pasted into the code as is.
+func (ws *Server) withVars(
+	block *loader.CodeBlock,
+	req *http.Request) (*loader.CodeBlock, []string, error) {
+	entered := make(loader.Vars)
+	for k, v := range req.URL.Query() {
+		name, ok := strings.CutPrefix(k, config.KeyVarPrefix)
+		if !ok || !loader.IsVarName(name) || len(v) == 0 || v[0] == "" {
+			continue
+		}
+		if !safeValueRe.MatchString(v[0]) {
+			return nil, nil, fmt.Errorf(
+				"the value of %s may hold only letters, digits and %q",
+				name, "_./:@%+=,-")
+		}
+		entered[name] = v[0]
+	}
+	sessID := ws.browserSession(req)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	now := time.Now()
+	for id, sv := range ws.sessVars {
+		if now.Sub(sv.lastUse) > sessionMaxAge {
+			// The session's cookie has expired.
+			delete(ws.sessVars, id)
+		}
+	}
+	sv, ok := ws.sessVars[sessID]
+	if !ok && len(entered) > 0 {
+		if ws.sessVars == nil {
+			ws.sessVars = make(map[session.TypeSessID]*sessionVars)
+		}
+		sv = &sessionVars{vars: make(loader.Vars)}
+		ws.sessVars[sessID] = sv
+	}
+	vars := maps.Clone(ws.vars)
+	if vars == nil {
+		vars = make(loader.Vars)
+	}
+	if sv != nil {
+		sv.lastUse = now
+		maps.Copy(sv.vars, entered)
+		maps.Copy(vars, sv.vars)
+	}
+	b, unresolved := block.WithVars(vars)
+	return b, unresolved, nil
+}
+
+// writerFor returns the writer for the block's session, falling
+// back to the codeWriter.
+func (ws *Server) writerFor(block *loader.CodeBlock) io.Writer {
+	sw, ok := ws.codeWriter.(SessionWriter)
+	if !ok || block.Session() == "" {
+		return ws.codeWriter
+	}
+	w, err := sw.ForSession(block.Session())
+	if err != nil {
+		slog.Error("no writer for session", "session", block.Session(), "err", err)
+		return ws.codeWriter
+	}
+	return w
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// cookieFor returns the cookie of a browser session with the given id.
+func cookieFor(t *testing.T, ws *Server, id session.TypeSessID) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	s, _ := ws.store.Get(req, cookieName)
+	session.AssureDefaults(s)
+	s.Values[config.KeyMdSessID] = id
+	wr := httptest.NewRecorder()
+	if err := s.Save(req, wr); err != nil {
+		t.Fatal(err)
+	}
+	return wr.Header().Get("Set-Cookie")
+}
+
+func TestWithVars(t *testing.T) {
+	ws, _ := NewServer(nil, nil, loader.Vars{"ZONE": "flagZone"})
+	block := loader.NewCodeBlock(nil, "echo ${ZONE} <your-project>\n", 0)
+	cookies := map[session.TypeSessID]string{
+		"s1": cookieFor(t, ws, "s1"),
+		"s2": cookieFor(t, ws, "s2"),
+	}
+	run := func(sessID session.TypeSessID, query string) (string, []string) {
+		req := httptest.NewRequest("POST", "/run?"+query, nil)
+		req.Header.Set("Cookie", cookies[sessID])
+		b, unresolved, err := ws.withVars(block, req)
+		assert.NoError(t, err)
+		return b.Code(), unresolved
+	}
+	p := config.KeyVarPrefix
+
+	_, unresolved := run("s1", "")
+	assert.Equal(t, []string{"your-project"}, unresolved)
+
+	code, unresolved := run("s1", p+"your-project=one")
+	assert.Empty(t, unresolved)
+	assert.Equal(t, "echo flagZone one\n", code)
+
+	// The session remembers its value.
+	code, unresolved = run("s1", "")
+	assert.Empty(t, unresolved)
+	assert.Equal(t, "echo flagZone one\n", code)
+
+	// Another session doesn't see it.
+	_, unresolved = run("s2", "")
+	assert.Equal(t, []string{"your-project"}, unresolved)
+
+	// A session's value overrides a flag's, in that session only.
+	code, _ = run("s2", p+"your-project=two&"+p+"ZONE=myZone")
+	assert.Equal(t, "echo myZone two\n", code)
+	code, _ = run("s1", "")
+	assert.Equal(t, "echo flagZone one\n", code)
+	assert.Equal(t, loader.Vars{"ZONE": "flagZone"}, ws.vars)
+
+	// The values of expired sessions are dropped.
+	ws.sessVars["s2"].lastUse = time.Now().Add(-sessionMaxAge - time.Minute)
+	_, _ = run("s1", "")
+	assert.NotContains(t, ws.sessVars, session.TypeSessID("s2"))
+	assert.Contains(t, ws.sessVars, session.TypeSessID("s1"))
+}
+
+func TestWithVarsRefusesShellSyntax(t *testing.T) {
+	ws, _ := NewServer(nil, nil, nil)
+	block := loader.NewCodeBlock(nil, "echo <your-project>\n", 0)
+	for _, v := range []string{
+		"a;rm -rf x", "$(id)", "`id`", "a b", "a|b", "a'b", "a\nb", "a>b",
+	} {
+		req := httptest.NewRequest("POST", "/run", nil)
+		q := req.URL.Query()
+		q.Set(config.KeyVarPrefix+"your-project", v)
+		req.URL.RawQuery = q.Encode()
+		_, _, err := ws.withVars(block, req)
+		assert.Error(t, err, v)
+	}
+	assert.Empty(t, ws.sessVars)
+}
+
+func TestRunCodeBlockRefusals(t *testing.T) {
+	ws, _ := NewServer(nil, nil, nil)
+	for name, tc := range map[string]struct {
+		method string
+		origin string
+		status int
+	}{
+		"get": {
+			method: "GET",
+			origin: "http://example.com",
+			status: http.StatusMethodNotAllowed,
+		},
+		"noOrigin": {
+			method: "POST",
+			status: http.StatusForbidden,
+		},
+		"otherOrigin": {
+			method: "POST",
+			origin: "http://evil.example.org",
+			status: http.StatusForbidden,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method,
+				"http://example.com"+config.Dynamic(config.RouteRunBlock), nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			wr := httptest.NewRecorder()
+			ws.handleRunCodeBlock(wr, req)
+			assert.Equal(t, tc.status, wr.Code)
+		})
+	}
+}
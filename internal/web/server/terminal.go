@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+)
+
+// terminalMsg is a message from the web app's terminal pane.
+type terminalMsg struct {
+	// Input holds keystrokes to type into the terminal.
+	Input string `json:"input,omitempty"`
+	// Rows and Cols, if not zero, are the size of the pane.
+	Rows int `json:"rows,omitempty"`
+	Cols int `json:"cols,omitempty"`
+}
+
+func (ws *Server) hasTerminal() bool {
+	_, ok := ws.codeWriter.(TerminalWriter)
+	return ok
+}
+
+// makeTerminalHandler returns a handler connecting a websocket to the
+// terminal.  Terminal output goes to the socket in binary messages,
+// since a chunk of output may end mid-character.  The web app sends
+// terminalMsg values as JSON text messages.
+func (ws *Server) makeTerminalHandler(tw TerminalWriter) http.Handler {
+	return websocket.Server{
+		Handshake: checkSameOrigin,
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			backlog, out, detach, err := tw.Attach()
+			if err != nil {
+				slog.Error("unable to attach terminal", "err", err)
+				return
+			}
+			defer detach()
+			go func() {
+				// Closing the socket ends the read loop below.
+				defer conn.Close()
+				if err := websocket.Message.Send(conn, backlog); err != nil {
+					return
+				}
+				for b := range out {
+					if err := websocket.Message.Send(conn, b); err != nil {
+						return
+					}
+				}
+			}()
+			for {
+				var data string
+				if err = websocket.Message.Receive(conn, &data); err != nil {
+					slog.Debug("terminal socket closed", "err", err)
+					return
+				}
+				var msg terminalMsg
+				if err = json.Unmarshal([]byte(data), &msg); err != nil {
+					slog.Warn("bad terminal message", "err", err)
+					continue
+				}
+				if msg.Rows > 0 && msg.Cols > 0 {
+					if err = tw.Resize(msg.Rows, msg.Cols); err != nil {
+						slog.Warn("unable to resize terminal", "err", err)
+					}
+				}
+				if msg.Input != "" {
+					if _, err = tw.Write([]byte(msg.Input)); err != nil {
+						slog.Error("unable to type into terminal", "err", err)
+					}
+				}
+			}
+		},
+	}
+}
+
+// checkSameOrigin refuses sockets opened by pages from other sites,
+// which would otherwise be able to type into the terminal.
+func checkSameOrigin(config *websocket.Config, req *http.Request) error {
+	origin, err := sameOrigin(req)
+	if err != nil {
+		return err
+	}
+	config.Origin = origin
+	return nil
+}
+
+// sameOrigin returns the origin of the request, or an error if the
+// request didn't come from a page served by this server.
+func sameOrigin(req *http.Request) (*url.URL, error) {
+	origin, err := url.Parse(req.Header.Get("Origin"))
+	if err != nil || origin.Host == "" || origin.Host != req.Host {
+		return nil, fmt.Errorf("refused to origin %q", req.Header.Get("Origin"))
+	}
+	return origin, nil
+}
@@ -6,9 +6,13 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/utils"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
 	"github.com/monopole/mdrip/v2/internal/web/config"
 	"github.com/monopole/mdrip/v2/internal/web/server/minify"
 )
@@ -23,6 +27,37 @@ var (
 	keyEncrypt = []byte(nil)
 )
 
+// SessionWriter is a codeWriter that can direct code blocks
+// to named sessions, e.g. tmux windows.
+type SessionWriter interface {
+	io.Writer
+	// ForSession returns a writer for the named session.
+	ForSession(name string) (io.Writer, error)
+}
+
+// StreamingRunner is a codeWriter that runs code blocks itself,
+// rather than sending them to, say, tmux, so that the web app can
+// show their output.
+type StreamingRunner interface {
+	io.Writer
+	// RunBlock runs the block in the shell for the key, passing each
+	// line of output to the func as it arrives, and returns the exit
+	// status of the block.
+	RunBlock(key string, b *loader.CodeBlock,
+		out func(isErr bool, line string)) (int, error)
+}
+
+// TerminalWriter is a codeWriter that types code blocks into a
+// terminal, which the web app shows in a pane.
+type TerminalWriter interface {
+	io.Writer
+	// Attach returns the terminal's recent output, and a channel of
+	// output to come, which is closed by detach.
+	Attach() (backlog []byte, out <-chan []byte, detach func(), err error)
+	// Resize sets the size of the terminal.
+	Resize(rows, cols int) error
+}
+
 // Server represents a webserver.
 type Server struct {
 	// dLoader loads markdown to serve.
@@ -34,15 +69,37 @@ type Server struct {
 	// codeblock if you reload (start a new session).
 	store sessions.Store
 	// codeWriter accepts codeblocks for execution or simply printing.
+	// If it's a SessionWriter, blocks with a session parameter go
+	// to the writer for that session.  If it's a StreamingRunner,
+	// blocks run in a shell per browser session, with their output
+	// streamed back to the browser.
 	codeWriter io.Writer
+	// vars holds values for placeholders in code blocks, from flags.
+	vars loader.Vars
+	// sessVars holds the values entered in the web app, per browser
+	// session; they take precedence over vars.  Guarded by mu.
+	sessVars map[session.TypeSessID]*sessionVars
+	mu       sync.Mutex
+}
+
+// sessionVars holds the values entered in one browser session.
+type sessionVars struct {
+	vars loader.Vars
+	// lastUse is when the session last ran a block, so that the
+	// values can be dropped once its cookie has expired.
+	lastUse time.Time
 }
 
-// NewServer returns a new web server.
-func NewServer(dl *DataLoader, r io.Writer) (*Server, error) {
+// sessionMaxAge is how long a browser session's cookie lasts.
+const sessionMaxAge = 8 * time.Hour
+
+// NewServer returns a new web server.  The vars, if any, hold
+// values for placeholders in code blocks.
+func NewServer(dl *DataLoader, r io.Writer, vars loader.Vars) (*Server, error) {
 	s := sessions.NewCookieStore(keyAuth, keyEncrypt)
 	s.Options = &sessions.Options{
 		Path:     "/",
-		MaxAge:   8 * 60 * 60, // 8 hours (Max-Age has units seconds)
+		MaxAge:   int(sessionMaxAge.Seconds()), // Max-Age has units seconds
 		HttpOnly: true,
 	}
 	return &Server{
@@ -50,6 +107,7 @@ func NewServer(dl *DataLoader, r io.Writer) (*Server, error) {
 		store:      s,
 		minifier:   minify.MakeMinifier(),
 		codeWriter: r,
+		vars:       vars,
 	}, nil
 }
 
@@ -60,7 +118,9 @@ func (ws *Server) Serve(hostAndPort string) (err error) {
 	http.HandleFunc(config.Dynamic(config.RouteQuit), ws.handleQuit)
 	http.HandleFunc(config.Dynamic(config.RouteDebug), ws.handleDebugPage)
 	http.HandleFunc(config.Dynamic(config.RouteReload), ws.handleReload)
-	// http.Handle(session.Dynamic(session.RouteWebSocket), ws.openWebSocket)
+	if tw, ok := ws.codeWriter.(TerminalWriter); ok {
+		http.Handle(config.Dynamic(config.RouteWebSocket), ws.makeTerminalHandler(tw))
+	}
 	http.HandleFunc(config.Dynamic(config.RouteJs), ws.handleGetJs)
 	http.HandleFunc(config.Dynamic(config.RouteCss), ws.handleGetCss)
 	http.HandleFunc(config.Dynamic(config.RouteLabelsForFile), ws.handleGetLabelsForFile)
@@ -5,10 +5,12 @@ import (
 
 	"github.com/monopole/mdrip/v2/internal/commands/list"
 
+	"github.com/monopole/mdrip/v2/internal/commands/export"
 	"github.com/monopole/mdrip/v2/internal/commands/generatetestdata"
 	"github.com/monopole/mdrip/v2/internal/commands/print"
 	"github.com/monopole/mdrip/v2/internal/commands/raw"
 	"github.com/monopole/mdrip/v2/internal/commands/serve"
+	"github.com/monopole/mdrip/v2/internal/commands/tangle"
 	"github.com/monopole/mdrip/v2/internal/commands/test"
 	"github.com/monopole/mdrip/v2/internal/commands/version"
 	"github.com/monopole/mdrip/v2/internal/loader"
@@ -37,6 +39,8 @@ func newCommand() *cobra.Command {
 		list.NewCommand(ldr, p),
 		serve.NewCommand(ldr, p),
 		test.NewCommand(ldr, p),
+		tangle.NewCommand(ldr, p),
+		export.NewCommand(ldr, p),
 		version.NewCommand(),
 		generatetestdata.NewCommand(),
 		// "tmux" websocket service disabled until a reasonable use case found.